@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// authEnabled requires a Bearer JWT on every route but the health checks,
+// version info, the OpenAPI document, metrics and CORS preflights. Tokens are
+// RS256 signed by a key from authJWKSURL and must carry authAudience and, if
+// set, authIssuer.
+var authEnabled = os.Getenv("AUTH_ENABLED") == "true"
+
+var (
+	authJWKSURL  = os.Getenv("AUTH_JWKS_URL")
+	authAudience = os.Getenv("AUTH_AUDIENCE")
+	authIssuer   = os.Getenv("AUTH_ISSUER")
+)
+
+// errUnauthenticated is returned for a missing, malformed, badly signed or
+// expired token.
+var errUnauthenticated = errors.New("invalid or missing bearer token")
+
+// errForbidden is returned for a valid token whose claims don't grant access.
+var errForbidden = errors.New("token not accepted for this API")
+
+// jwksTimeout bounds fetching the signing keys.
+const jwksTimeout = 2 * time.Second
+
+// jwksRefresh is how long fetched keys are used before they are fetched
+// again. An unknown key id triggers a refetch at most every jwksMinRefetch,
+// so rotated keys are picked up without hammering the JWKS endpoint.
+const (
+	jwksRefresh    = time.Hour
+	jwksMinRefetch = time.Minute
+)
+
+// requiresAuth reports whether dispatch checks credentials for req.
+func requiresAuth(req request) bool {
+	switch {
+	case req.HTTPMethod == "OPTIONS",
+		req.Path == "/api/health",
+		req.Path == "/api/ready",
+		req.Path == "/api/version",
+		req.Path == "/api/openapi.json",
+		req.Path == "/metrics":
+		return false
+	default:
+		return true
+	}
+}
+
+type subjectKey struct{}
+
+// withSubject stores the sub claim of the caller's token.
+func withSubject(ctx context.Context, sub string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, sub)
+}
+
+// subjectFrom returns the caller's sub claim, or "" when auth is disabled.
+func subjectFrom(ctx context.Context) string {
+	sub, _ := ctx.Value(subjectKey{}).(string)
+	return sub
+}
+
+// authenticate verifies the Bearer token of req and returns ctx carrying its
+// subject.
+func authenticate(ctx context.Context, req request) (context.Context, error) {
+	token, ok := strings.CutPrefix(requestHeader(req, "Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ctx, fmt.Errorf("%w: no bearer token", errUnauthenticated)
+	}
+
+	claims, err := verifyToken(ctx, strings.TrimSpace(token), time.Now())
+	if err != nil {
+		return ctx, err
+	}
+
+	ctx = withSubject(ctx, claims.Subject)
+	ctx = withLogAttrs(ctx, slog.String("subject", claims.Subject))
+
+	return ctx, nil
+}
+
+// authError answers a request authenticate rejected. Failing to fetch the
+// signing keys isn't the client's fault and is a server error, logged under
+// the auth category so identity provider outages aren't taken for the store's.
+func authError(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	if !errors.Is(err, errUnauthenticated) && !errors.Is(err, errForbidden) {
+		return serverError(ctx, &categorizedError{category: categoryAuth, err: err})
+	}
+
+	logger.InfoContext(ctx, "Rejected credentials", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+
+	if errors.Is(err, errForbidden) {
+		return clientError(http.StatusForbidden)
+	}
+
+	res, err := clientError(http.StatusUnauthorized)
+	res.Headers["WWW-Authenticate"] = `Bearer error="invalid_token"`
+
+	return res, err
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+// audiences returns the aud claim, which is either a string or a list.
+func (c jwtClaims) audiences() []string {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return []string{single}
+	}
+
+	var list []string
+	json.Unmarshal(c.Audience, &list)
+
+	return list
+}
+
+// verifyToken checks the signature and lifetime of a compact JWT, then its
+// audience and issuer. Only RS256 is accepted, so "none" or HMAC tokens
+// signed with the public key are rejected.
+func verifyToken(ctx context.Context, token string, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", errUnauthenticated)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", errUnauthenticated, err)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported alg %q", errUnauthenticated, header.Alg)
+	}
+
+	key, err := signingKeys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", errUnauthenticated, err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", errUnauthenticated, err)
+	}
+
+	switch {
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: no exp claim", errUnauthenticated)
+	case now.Unix() >= *claims.ExpiresAt:
+		return nil, fmt.Errorf("%w: token expired", errUnauthenticated)
+	case claims.NotBefore != nil && now.Unix() < *claims.NotBefore:
+		return nil, fmt.Errorf("%w: token not valid yet", errUnauthenticated)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no sub claim", errUnauthenticated)
+	}
+
+	if authIssuer != "" && claims.Issuer != authIssuer {
+		return nil, fmt.Errorf("%w: issuer %q", errForbidden, claims.Issuer)
+	}
+
+	if !contains(claims.audiences(), authAudience) {
+		return nil, fmt.Errorf("%w: audience %q", errForbidden, claims.Audience)
+	}
+
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jwks caches the RSA signing keys published at url, by key id.
+type jwks struct {
+	url string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+var signingKeys = &jwks{url: authJWKSURL}
+
+func (k *jwks) get(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	key, ok := k.keys[kid]
+	stale := time.Since(k.fetched) > jwksRefresh
+	if ok && !stale {
+		return key, nil
+	}
+
+	if stale || time.Since(k.fetched) > jwksMinRefetch {
+		keys, err := fetchJWKS(ctx, k.url)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys = keys
+		k.fetched = time.Now()
+		key, ok = keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", errUnauthenticated, kid)
+	}
+
+	return key, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func fetchJWKS(ctx context.Context, url string) (map[string]*rsa.PublicKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, jwksTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %s", res.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding JWKS key %q: %w", jwk.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding JWKS key %q: %w", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestIssuer serves a JWKS with a fresh signing key as signingKeys for the
+// rest of the test and returns a function signing tokens with it.
+func newTestIssuer(t *testing.T) func(claims map[string]any) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+	setFor(t, &signingKeys, &jwks{url: srv.URL})
+
+	return func(claims map[string]any) string {
+		t.Helper()
+
+		segment := func(v any) string {
+			data, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return base64.RawURLEncoding.EncodeToString(data)
+		}
+
+		signed := segment(jwtHeader{Alg: "RS256", Kid: "test"}) + "." + segment(claims)
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	setFor(t, &authAudience, "todo-api")
+	setFor(t, &authIssuer, "")
+	sign := newTestIssuer(t)
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr error
+	}{
+		{name: "valid", claims: map[string]any{"sub": "alice", "aud": "todo-api", "exp": exp}},
+		{name: "audience list", claims: map[string]any{"sub": "alice", "aud": []string{"other-api", "todo-api"}, "exp": exp}},
+		{name: "other audience", claims: map[string]any{"sub": "alice", "aud": "other-api", "exp": exp}, wantErr: errForbidden},
+		{name: "no audience", claims: map[string]any{"sub": "alice", "exp": exp}, wantErr: errForbidden},
+		{name: "expired", claims: map[string]any{"sub": "alice", "aud": "todo-api", "exp": now.Add(-time.Minute).Unix()}, wantErr: errUnauthenticated},
+		{name: "no subject", claims: map[string]any{"aud": "todo-api", "exp": exp}, wantErr: errUnauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyToken(context.Background(), sign(tt.claims), now)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("verifyToken = %v, want no error", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyToken = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenRejectsUnsignedTokens(t *testing.T) {
+	setFor(t, &authAudience, "todo-api")
+	newTestIssuer(t)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","aud":"todo-api","exp":9999999999}`))
+
+	_, err := verifyToken(context.Background(), header+"."+claims+".", time.Now())
+	if !errors.Is(err, errUnauthenticated) {
+		t.Errorf("verifyToken = %v, want errUnauthenticated", err)
+	}
+}
+
+func TestOwnersAreIsolated(t *testing.T) {
+	setFor(t, &authEnabled, true)
+	setFor(t, &authAudience, "todo-api")
+	sign := newTestIssuer(t)
+	h := newHandler(newMemoryStore())
+
+	as := func(sub string, req request) request {
+		token := sign(map[string]any{"sub": sub, "aud": "todo-api", "exp": time.Now().Add(time.Hour).Unix()})
+		req.Headers = map[string]string{"Authorization": "Bearer " + token}
+		return req
+	}
+
+	res := serve(t, h, as("alice", request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"alice's task"}`}))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST as alice = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+	}
+	var todo Todo
+	decodeData(t, res, &todo)
+
+	byID := func(method, path string) request {
+		return request{HTTPMethod: method, Path: path + todo.Id, PathParameters: map[string]string{"id": todo.Id}}
+	}
+	for _, req := range []request{
+		byID("GET", "/api/task/"),
+		byID("PUT", "/api/task/"),
+		byID("DELETE", "/api/deleteTask/"),
+	} {
+		res := serve(t, h, as("bob", req))
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s as bob = %d, want %d", req.HTTPMethod, req.Path, res.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	res = serve(t, h, as("bob", request{HTTPMethod: "GET", Path: "/api/task"}))
+	var page struct {
+		Items []Todo `json:"items"`
+	}
+	decodeData(t, res, &page)
+	if len(page.Items) != 0 {
+		t.Errorf("bob lists %d todos, want none", len(page.Items))
+	}
+
+	res = serve(t, h, as("alice", byID("GET", "/api/task/")))
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("GET as alice = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	res = serve(t, h, request{HTTPMethod: "GET", Path: "/api/task"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without a token = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestJWKSFailureIsAuthServerError(t *testing.T) {
+	setFor(t, &authEnabled, true)
+	setFor(t, &authAudience, "todo-api")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "identity provider down", http.StatusBadGateway)
+	}))
+	t.Cleanup(srv.Close)
+
+	// The token is fine, but its key can't be fetched.
+	token := newTestIssuer(t)(map[string]any{"sub": "alice", "aud": "todo-api", "exp": time.Now().Add(time.Hour).Unix()})
+	setFor(t, &signingKeys, &jwks{url: srv.URL})
+	logs := captureLogs(t, slog.LevelError)
+
+	res := serve(t, newHandler(newMemoryStore()), request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers:    map[string]string{"Authorization": "Bearer " + token},
+	})
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusInternalServerError, res.Body)
+	}
+	if !strings.Contains(logs.String(), `"error_category":"auth"`) {
+		t.Errorf("logs = %s, want the error under the auth category", logs)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// breakerThreshold is how many consecutive store failures open the circuit
+// breaker. Zero disables it.
+var breakerThreshold = envInt("BREAKER_THRESHOLD", 5)
+
+// breakerCooldown is how long an open breaker fails calls fast before it lets
+// calls through again to probe the store.
+var breakerCooldown = time.Duration(envInt("BREAKER_COOLDOWN_MS", 10000)) * time.Millisecond
+
+// breakerStore is a TodoStore that stops calling next after breakerThreshold
+// failures in a row, so that during an outage requests fail fast with
+// ErrCircuitOpen instead of each waiting out operationTimeout. Once the
+// cooldown has passed, the next call decides: a success closes the breaker, a
+// failure opens it again. Like all state here, it is per container.
+type breakerStore struct {
+	next TodoStore
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreakerStore(next TodoStore) *breakerStore {
+	return &breakerStore{next: next}
+}
+
+// do runs call unless the breaker is open, and counts its outcome.
+func (b *breakerStore) do(ctx context.Context, call func() error) error {
+	err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	err = call()
+	b.record(ctx, err)
+
+	return err
+}
+
+func (b *breakerStore) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+
+	return nil
+}
+
+func (b *breakerStore) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isStoreFailure(err) {
+		if b.failures >= breakerThreshold {
+			logger.InfoContext(ctx, "Store circuit breaker closed")
+			emitMetrics(ctx, breakerMetrics(false))
+		}
+
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = time.Now().Add(breakerCooldown)
+		logger.WarnContext(ctx, "Store circuit breaker opened",
+			slog.Int("failures", b.failures),
+			slog.Duration("cooldown", breakerCooldown),
+			slog.String("error", err.Error()),
+		)
+		emitMetrics(ctx, breakerMetrics(true))
+	}
+}
+
+// isStoreFailure reports whether err means the store itself is unwell, as
+// opposed to a rejected request or a client that went away.
+func isStoreFailure(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, ErrNotFound),
+		errors.Is(err, ErrConflict),
+		errors.Is(err, ErrInvalidCursor),
+		errors.Is(err, context.Canceled):
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *breakerStore) Ping(ctx context.Context) error {
+	return b.do(ctx, func() error {
+		return b.next.Ping(ctx)
+	})
+}
+
+func (b *breakerStore) Get(ctx context.Context, id string, opts GetOptions) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Get(ctx, id, opts)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) List(ctx context.Context, opts ListOptions) (todos []Todo, cursor string, err error) {
+	err = b.do(ctx, func() error {
+		todos, cursor, err = b.next.List(ctx, opts)
+		return err
+	})
+
+	return todos, cursor, err
+}
+
+func (b *breakerStore) Count(ctx context.Context) (total int, completed int, err error) {
+	err = b.do(ctx, func() error {
+		total, completed, err = b.next.Count(ctx)
+		return err
+	})
+
+	return total, completed, err
+}
+
+func (b *breakerStore) Search(ctx context.Context, opts SearchOptions) (todos []Todo, cursor string, err error) {
+	err = b.do(ctx, func() error {
+		todos, cursor, err = b.next.Search(ctx, opts)
+		return err
+	})
+
+	return todos, cursor, err
+}
+
+func (b *breakerStore) Insert(ctx context.Context, createTodo CreateTodo) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Insert(ctx, createTodo)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) InsertIdempotent(ctx context.Context, createTodo CreateTodo, key string) (todo *Todo, created bool, err error) {
+	err = b.do(ctx, func() error {
+		todo, created, err = b.next.InsertIdempotent(ctx, createTodo, key)
+		return err
+	})
+
+	return todo, created, err
+}
+
+func (b *breakerStore) BatchInsert(ctx context.Context, createTodos []CreateTodo) (todos []Todo, err error) {
+	err = b.do(ctx, func() error {
+		todos, err = b.next.BatchInsert(ctx, createTodos)
+		return err
+	})
+
+	return todos, err
+}
+
+func (b *breakerStore) UpdateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.UpdateStatus(ctx, id, updateTodo, version)
+		return err
+	})
+
+	return todo, err
+}
+
+// SetStatuses counts as a failure when any of the updates failed.
+func (b *breakerStore) SetStatuses(ctx context.Context, ids []string, status bool) []StatusChange {
+	var changes []StatusChange
+	err := b.do(ctx, func() error {
+		changes = b.next.SetStatuses(ctx, ids, status)
+		for _, change := range changes {
+			if isStoreFailure(change.Err) {
+				return change.Err
+			}
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, ErrCircuitOpen) {
+		changes = make([]StatusChange, 0, len(ids))
+		for _, id := range ids {
+			changes = append(changes, StatusChange{Id: id, Err: err})
+		}
+	}
+
+	return changes
+}
+
+func (b *breakerStore) Toggle(ctx context.Context, id string) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Toggle(ctx, id)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) Patch(ctx context.Context, id string, patchTodo PatchTodo, version int) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Patch(ctx, id, patchTodo, version)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) Replace(ctx context.Context, id string, replaceTodo ReplaceTodo, version int) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Replace(ctx, id, replaceTodo, version)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) Delete(ctx context.Context, id string) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Delete(ctx, id)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) SoftDelete(ctx context.Context, id string) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.SoftDelete(ctx, id)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) Restore(ctx context.Context, id string) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.Restore(ctx, id)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) SetPosition(ctx context.Context, id string, position float64) (todo *Todo, err error) {
+	err = b.do(ctx, func() error {
+		todo, err = b.next.SetPosition(ctx, id, position)
+		return err
+	})
+
+	return todo, err
+}
+
+func (b *breakerStore) DeleteAll(ctx context.Context) (deleted int, err error) {
+	err = b.do(ctx, func() error {
+		deleted, err = b.next.DeleteAll(ctx)
+		return err
+	})
+
+	return deleted, err
+}
+
+func (b *breakerStore) AppendHistory(ctx context.Context, entry HistoryEntry) error {
+	return b.do(ctx, func() error {
+		return b.next.AppendHistory(ctx, entry)
+	})
+}
+
+func (b *breakerStore) History(ctx context.Context, id string) (entries []HistoryEntry, err error) {
+	err = b.do(ctx, func() error {
+		entries, err = b.next.History(ctx, id)
+		return err
+	})
+
+	return entries, err
+}
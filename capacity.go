@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// capacityDebug asks DynamoDB for the capacity every call consumes, and
+// reports it per call in the logs and per request on the trace. It makes
+// responses slightly larger, so it is off unless DYNAMODB_CAPACITY_DEBUG is
+// true.
+var capacityDebug = os.Getenv("DYNAMODB_CAPACITY_DEBUG") == "true"
+
+// capacityUsage adds up the capacity units the DynamoDB calls of a request
+// consumed.
+type capacityUsage struct {
+	mu    sync.Mutex
+	read  float64
+	write float64
+}
+
+type capacityKey struct{}
+
+func withCapacityUsage(ctx context.Context) (context.Context, *capacityUsage) {
+	usage := new(capacityUsage)
+	return context.WithValue(ctx, capacityKey{}, usage), usage
+}
+
+func (u *capacityUsage) add(read, write float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.read += read
+	u.write += write
+}
+
+func (u *capacityUsage) totals() (float64, float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.read, u.write
+}
+
+// reportCapacity sets ReturnConsumedCapacity=TOTAL on every call and records
+// what comes back.
+func reportCapacity(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ReportCapacity",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			requestCapacity(in.Parameters)
+
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if err != nil {
+				return out, metadata, err
+			}
+
+			units, read := consumedCapacity(out.Result)
+			if units == 0 {
+				return out, metadata, err
+			}
+
+			logger.InfoContext(ctx, "DynamoDB consumed capacity",
+				slog.String("operation", awsmiddleware.GetOperationName(ctx)),
+				slog.Float64("capacity_units", units),
+			)
+
+			if usage, ok := ctx.Value(capacityKey{}).(*capacityUsage); ok {
+				if read {
+					usage.add(units, 0)
+				} else {
+					usage.add(0, units)
+				}
+			}
+
+			return out, metadata, err
+		},
+	), middleware.After)
+}
+
+func requestCapacity(params any) {
+	switch input := params.(type) {
+	case *dynamodb.GetItemInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.QueryInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.ScanInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.PutItemInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.UpdateItemInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.DeleteItemInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.BatchWriteItemInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	case *dynamodb.TransactWriteItemsInput:
+		input.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	}
+}
+
+// consumedCapacity returns the capacity units an operation's output reports
+// and whether they are read units.
+func consumedCapacity(result any) (float64, bool) {
+	switch output := result.(type) {
+	case *dynamodb.GetItemOutput:
+		return capacityUnits(output.ConsumedCapacity), true
+	case *dynamodb.QueryOutput:
+		return capacityUnits(output.ConsumedCapacity), true
+	case *dynamodb.ScanOutput:
+		return capacityUnits(output.ConsumedCapacity), true
+	case *dynamodb.PutItemOutput:
+		return capacityUnits(output.ConsumedCapacity), false
+	case *dynamodb.UpdateItemOutput:
+		return capacityUnits(output.ConsumedCapacity), false
+	case *dynamodb.DeleteItemOutput:
+		return capacityUnits(output.ConsumedCapacity), false
+	case *dynamodb.BatchWriteItemOutput:
+		return capacityUnitsOf(output.ConsumedCapacity), false
+	case *dynamodb.TransactWriteItemsOutput:
+		return capacityUnitsOf(output.ConsumedCapacity), false
+	default:
+		return 0, false
+	}
+}
+
+func capacityUnits(capacity *types.ConsumedCapacity) float64 {
+	if capacity == nil || capacity.CapacityUnits == nil {
+		return 0
+	}
+
+	return *capacity.CapacityUnits
+}
+
+// capacityUnitsOf adds up the per-table capacity of multi-table operations.
+func capacityUnitsOf(capacities []types.ConsumedCapacity) float64 {
+	total := 0.0
+	for i := range capacities {
+		total += capacityUnits(&capacities[i])
+	}
+
+	return total
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// chaosLatency delays every API response by about this long, to check that
+// dashboards, alerts and traces show slow requests. It is jittered by up to
+// half in either direction. Zero disables it.
+var chaosLatency = time.Duration(envInt("CHAOS_LATENCY_MS", 0)) * time.Millisecond
+
+// chaosErrorRate is the fraction of API requests, from 0 to 1, answered with
+// an injected 500. Zero disables it.
+var chaosErrorRate = envFloat("CHAOS_ERROR_RATE", 0)
+
+// errChaos is the error behind an injected 500.
+var errChaos = errors.New("error injected by CHAOS_ERROR_RATE")
+
+// chaosApplies reports whether chaos may be injected into req. Health checks,
+// version info and metrics are spared, so that chaos doesn't take the deployment down.
+func chaosApplies(req request) bool {
+	if chaosLatency <= 0 && chaosErrorRate <= 0 {
+		return false
+	}
+
+	switch {
+	case req.HTTPMethod == "OPTIONS",
+		req.Path == "/api/health",
+		req.Path == "/api/ready",
+		req.Path == "/api/version",
+		req.Path == "/metrics":
+		return false
+	default:
+		return true
+	}
+}
+
+// injectChaos sleeps for the jittered chaosLatency, then returns errChaos for
+// chaosErrorRate of the calls. Both are logged and recorded on the trace.
+func injectChaos(ctx context.Context) error {
+	if chaosLatency > 0 {
+		delay := time.Duration(float64(chaosLatency) * (0.5 + rand.Float64()))
+		logger.WarnContext(ctx, "Injecting chaos latency", slog.Int64("delay_ms", delay.Milliseconds()))
+		recordChaos(ctx, "latency", attribute.Int64("chaos.delay_ms", delay.Milliseconds()))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if chaosErrorRate > 0 && rand.Float64() < chaosErrorRate {
+		logger.WarnContext(ctx, "Injecting chaos error", slog.Float64("error_rate", chaosErrorRate))
+		recordChaos(ctx, "error")
+
+		return &categorizedError{category: categoryChaos, err: errChaos}
+	}
+
+	return nil
+}
+
+func recordChaos(ctx context.Context, kind string, attrs ...attribute.KeyValue) {
+	if seg := xray.GetSegment(ctx); seg != nil {
+		seg.AddAnnotation("chaos", kind)
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("chaos."+kind, trace.WithAttributes(attrs...))
+}
@@ -36,7 +36,7 @@ class ToDoList extends Component {
           },
           {
             headers: {
-              "Content-Type": "application/x-www-form-urlencoded",
+              "Content-Type": "application/json",
             },
           }
         )
@@ -50,66 +50,80 @@ class ToDoList extends Component {
     }
   };
 
-  getTask = () => {
-    axios.get(endpoint + "/api/task").then((res) => {
-      if (res.data) {
-        this.setState({
-          items: res.data.map((item) => {
-            let color = "yellow";
-            let style = {
-              wordWrap: "break-word",
-            };
-
-            if (item.status) {
-              color = "green";
-              style["textDecorationLine"] = "line-through";
-            }
-
-            return (
-              <Card key={item.id} color={color} fluid>
-                <Card.Content>
-                  <Card.Header textAlign="left">
-                    <div style={style}>{item.task}</div>
-                  </Card.Header>
-
-                  <Card.Meta textAlign="right">
-                    <Icon
-                      name="check circle"
-                      color="green"
-                      onClick={() => this.updateTask(item.id)}
-                    />
-                    <span style={{ paddingRight: 10 }}>Done</span>
-                    <Icon
-                      name="undo"
-                      color="yellow"
-                      onClick={() => this.undoTask(item.id)}
-                    />
-                    <span style={{ paddingRight: 10 }}>Undo</span>
-                    <Icon
-                      name="delete"
-                      color="red"
-                      onClick={() => this.deleteTask(item.id)}
-                    />
-                    <span style={{ paddingRight: 10 }}>Delete</span>
-                  </Card.Meta>
-                </Card.Content>
-              </Card>
-            );
-          }),
-        });
-      } else {
-        this.setState({
-          items: [],
-        });
+  // The API returns todos a page at a time, so follow nextCursor until the
+  // last page. The list is refetched right after every change, so read
+  // consistently to see it.
+  fetchTodos = (cursor, todos) => {
+    const params = { limit: 100, consistent: true };
+    if (cursor) {
+      params.cursor = cursor;
+    }
+
+    return axios.get(endpoint + "/api/task", { params }).then((res) => {
+      const page = (res.data && res.data.data) || {};
+      const all = todos.concat(page.items || []);
+      if (page.nextCursor) {
+        return this.fetchTodos(page.nextCursor, all);
       }
+
+      return all;
     });
   };
 
-  updateTask = (id) => {
+  getTask = () => {
+    this.fetchTodos(null, []).then((todos) => {
+      this.setState({
+        items: todos.map((item) => {
+          let color = "yellow";
+          let style = {
+            wordWrap: "break-word",
+          };
+
+          if (item.status) {
+            color = "green";
+            style["textDecorationLine"] = "line-through";
+          }
+
+          return (
+            <Card key={item.id} color={color} fluid>
+              <Card.Content>
+                <Card.Header textAlign="left">
+                  <div style={style}>{item.task}</div>
+                </Card.Header>
+
+                <Card.Meta textAlign="right">
+                  <Icon
+                    name="check circle"
+                    color="green"
+                    onClick={() => this.updateTask(item)}
+                  />
+                  <span style={{ paddingRight: 10 }}>Done</span>
+                  <Icon
+                    name="undo"
+                    color="yellow"
+                    onClick={() => this.undoTask(item)}
+                  />
+                  <span style={{ paddingRight: 10 }}>Undo</span>
+                  <Icon
+                    name="delete"
+                    color="red"
+                    onClick={() => this.deleteTask(item.id)}
+                  />
+                  <span style={{ paddingRight: 10 }}>Delete</span>
+                </Card.Meta>
+              </Card.Content>
+            </Card>
+          );
+        }),
+      });
+    });
+  };
+
+  updateTask = (item) => {
     axios
-      .put(endpoint + "/api/task/" + id, {
+      .post(endpoint + "/api/task/" + item.id + "/complete", null, {
         headers: {
-          "Content-Type": "application/x-www-form-urlencoded",
+          "If-Match": String(item.version),
         },
       })
       .then((res) => {
@@ -118,11 +132,11 @@ class ToDoList extends Component {
       });
   };
 
-  undoTask = (id) => {
+  undoTask = (item) => {
     axios
-      .put(endpoint + "/api/undoTask/" + id, {
+      .post(endpoint + "/api/task/" + item.id + "/incomplete", null, {
         headers: {
-          "Content-Type": "application/x-www-form-urlencoded",
+          "If-Match": String(item.version),
         },
       })
       .then((res) => {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// compressMinBytes is the smallest response body worth gzipping.
+var compressMinBytes = envInt("COMPRESS_MIN_BYTES", 1024)
+
+// compressResponse gzips the body of res when the client accepts gzip and the
+// body is large enough to benefit. The compressed body is base64 encoded, which
+// API Gateway decodes before answering since every media type is binary.
+func compressResponse(res events.APIGatewayProxyResponse, acceptEncoding string) events.APIGatewayProxyResponse {
+	if len(res.Body) < compressMinBytes || res.IsBase64Encoded || res.Headers["Content-Encoding"] != "" {
+		return res
+	}
+
+	res.Headers = mergeHeaders(res.Headers, map[string]string{
+		"Vary": addVary(res.Headers["Vary"], "Accept-Encoding"),
+	})
+
+	if !acceptsGzip(acceptEncoding) {
+		return res
+	}
+
+	// Writes to a bytes.Buffer can't fail.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(res.Body))
+	zw.Close()
+
+	res.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+	res.IsBase64Encoded = true
+	res.Headers["Content-Encoding"] = "gzip"
+
+	return res
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through a wildcard, and doesn't rule it out with q=0.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func addVary(vary string, header string) string {
+	if vary == "" {
+		return header
+	}
+
+	return vary + ", " + header
+}
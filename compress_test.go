@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestCompressedListRoundTrips(t *testing.T) {
+	store := newMemoryStore()
+	for range 50 {
+		if _, err := store.Insert(context.Background(), CreateTodo{Task: strings.Repeat("write tests ", 5)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := serve(t, newHandler(store), request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers:    map[string]string{"Accept-Encoding": "br, gzip"},
+	})
+	if res.Headers["Content-Encoding"] != "gzip" || !res.IsBase64Encoded {
+		t.Fatalf("Content-Encoding = %q, IsBase64Encoded = %v, want a gzipped body", res.Headers["Content-Encoding"], res.IsBase64Encoded)
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var page struct {
+		Items []Todo `json:"items"`
+	}
+	decodeData(t, events.APIGatewayProxyResponse{Body: string(body)}, &page)
+	if len(page.Items) == 0 {
+		t.Errorf("decompressed body has no todos: %s", body)
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := strings.Repeat("x", compressMinBytes)
+	small := large[1:]
+
+	tests := []struct {
+		name           string
+		body           string
+		acceptEncoding string
+		wantGzip       bool
+		wantVary       bool
+	}{
+		{name: "large", body: large, acceptEncoding: "gzip", wantGzip: true, wantVary: true},
+		{name: "below threshold", body: small, acceptEncoding: "gzip"},
+		{name: "not accepted", body: large, acceptEncoding: "br", wantVary: true},
+		{name: "wildcard", body: large, acceptEncoding: "*", wantGzip: true, wantVary: true},
+		{name: "refused", body: large, acceptEncoding: "gzip;q=0, br", wantVary: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := compressResponse(events.APIGatewayProxyResponse{Body: tt.body}, tt.acceptEncoding)
+			if got := res.Headers["Content-Encoding"] == "gzip"; got != tt.wantGzip {
+				t.Errorf("gzipped = %v, want %v", got, tt.wantGzip)
+			}
+			if res.IsBase64Encoded != tt.wantGzip {
+				t.Errorf("IsBase64Encoded = %v, want %v", res.IsBase64Encoded, tt.wantGzip)
+			}
+			if got := res.Headers["Vary"] == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding %v", res.Headers["Vary"], tt.wantVary)
+			}
+		})
+	}
+}
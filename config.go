@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// envInt reads an integer environment variable, returning fallback when it is
+// unset. An unparsable value is a deployment mistake, so it fails fast.
+func envInt(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Error("Invalid integer environment variable", slog.String("name", name), slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return n
+}
+
+// envFloat reads a floating point environment variable like envInt.
+func envFloat(name string, fallback float64) float64 {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		logger.Error("Invalid number environment variable", slog.String("name", name), slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return f
+}
+
+// envLogLevel reads a log level environment variable, returning fallback when
+// it is unset. It runs before logger exists, so it reports a bad value through
+// the default logger.
+func envLogLevel(name string, fallback slog.Level) slog.Level {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(value))
+	if err != nil {
+		slog.Error("Invalid log level environment variable", slog.String("name", name), slog.String("value", value))
+		os.Exit(1)
+	}
+
+	return level
+}
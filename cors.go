@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+const corsAllowMethods = "GET, POST, PUT, DELETE, PATCH, OPTIONS"
+
+// allowedOrigins is read from the comma-separated ALLOWED_ORIGINS environment
+// variable. A nil allowlist means every origin is allowed.
+var allowedOrigins = parseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS"))
+
+// corsMaxAge is how many seconds browsers may cache a preflight response,
+// read from CORS_MAX_AGE.
+var corsMaxAge = envInt("CORS_MAX_AGE", 600)
+
+// corsAllowCredentials lets browsers send cookies and Authorization headers
+// with cross-origin requests. Browsers ignore it next to a wildcard origin, so
+// it needs an ALLOWED_ORIGINS allowlist, which checkCORS enforces at startup.
+var corsAllowCredentials = os.Getenv("CORS_ALLOW_CREDENTIALS") == "true"
+
+// checkCORS reports a CORS configuration browsers would reject.
+func checkCORS() error {
+	if corsAllowCredentials && (allowedOrigins == nil || allowedOrigins["*"]) {
+		return errors.New("CORS_ALLOW_CREDENTIALS requires ALLOWED_ORIGINS to list specific origins")
+	}
+
+	if corsMaxAge < 0 {
+		return errors.New("CORS_MAX_AGE must not be negative")
+	}
+
+	return nil
+}
+
+func parseAllowedOrigins(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+
+	origins := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+
+	return origins
+}
+
+// corsHeaders returns the CORS headers for a response to a request sent from
+// origin. The Allow-Origin header is omitted when origin isn't allowed.
+func corsHeaders(origin string) map[string]string {
+	headers := map[string]string{
+		"Access-Control-Allow-Headers":  "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Request-ID, traceparent, tracestate",
+		"Access-Control-Expose-Headers": "Deprecation, ETag, Link, Location, Retry-After, X-Export-Truncated, X-Request-ID, X-Served-By-Region, X-Total-Count",
+	}
+
+	switch {
+	case allowedOrigins == nil:
+		headers["Access-Control-Allow-Origin"] = "*"
+	case allowedOrigins[origin]:
+		headers["Access-Control-Allow-Origin"] = origin
+		headers["Vary"] = "Origin"
+		if corsAllowCredentials {
+			headers["Access-Control-Allow-Credentials"] = "true"
+		}
+	}
+
+	return headers
+}
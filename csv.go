@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// csvHeader names the columns of a todo list exported as CSV.
+var csvHeader = []string{"id", "task", "status", "createdAt"}
+
+// prefersCSV reports whether an Accept header ranks text/csv above
+// application/json. JSON stays the default for wildcards and missing headers.
+func prefersCSV(header string) bool {
+	csvWeight, jsonWeight := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			q, ok := strings.CutPrefix(strings.TrimSpace(param), "q=")
+			if !ok {
+				continue
+			}
+
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				weight = parsed
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/csv":
+			csvWeight = weight
+		case "application/json":
+			jsonWeight = weight
+		}
+	}
+
+	return csvWeight > 0 && csvWeight > jsonWeight
+}
+
+// listCSV answers a list preferring CSV. Like processExport, it pages through
+// List at maxPageSize from ?cursor and stops at a page boundary once the body
+// reaches exportMaxBytes, in which case the response carries
+// X-Export-Truncated and a Link to the rest. sortBy orders each part.
+func (h *handler) listCSV(ctx context.Context, req request, opts ListOptions, sortBy string) (events.APIGatewayProxyResponse, error) {
+	opts.Limit = maxPageSize
+
+	todos := make([]Todo, 0)
+	var size countingWriter
+	sizer := newCSVWriter(&size)
+	sizer.Write(csvHeader)
+	for {
+		page, cursor, err := h.store.List(ctx, opts)
+		if errors.Is(err, ErrInvalidCursor) {
+			logger.InfoContext(ctx, "Invalid cursor", slog.String("cursor", opts.Cursor), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+			return clientErrorMessage(http.StatusBadRequest, "invalid or expired cursor")
+		}
+		if err != nil {
+			return serverError(ctx, err)
+		}
+
+		todos = append(todos, page...)
+		for _, todo := range page {
+			sizer.Write(csvRecord(todo))
+		}
+		sizer.Flush()
+		opts.Cursor = cursor
+
+		if cursor == "" || int(size) >= exportMaxBytes {
+			break
+		}
+	}
+	sortTodos(todos, sortBy)
+
+	res, err := csvResponse(ctx, todos)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	if opts.Cursor != "" {
+		logger.WarnContext(ctx, "Truncated CSV list", slog.Int("count", len(todos)), slog.Int("bytes", int(size)), slog.String("next_cursor", opts.Cursor))
+		res.Headers["X-Export-Truncated"] = "true"
+		res.Headers["Link"] = fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(ctx, req, opts.Cursor))
+	}
+	logger.InfoContext(ctx, "Successfully listed todos as CSV", slog.Int("count", len(todos)), slog.Int("bytes", int(size)))
+
+	return withVaryAccept(res), nil
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// newCSVWriter writes RFC 4180 CSV, with CRLF line endings, to w.
+func newCSVWriter(w io.Writer) *csv.Writer {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+
+	return cw
+}
+
+// csvRecord is the row of todo under csvHeader.
+func csvRecord(todo Todo) []string {
+	return []string{todo.Id, todo.Task, strconv.FormatBool(todo.Status), todo.CreatedAt}
+}
+
+// csvResponse renders todos as RFC 4180 CSV, with a header row and CRLF line
+// endings. encoding/csv quotes tasks containing commas, quotes or newlines.
+func csvResponse(ctx context.Context, todos []Todo) (events.APIGatewayProxyResponse, error) {
+	var buf bytes.Buffer
+	w := newCSVWriter(&buf)
+
+	w.Write(csvHeader)
+	for _, todo := range todos {
+		w.Write(csvRecord(todo))
+	}
+	w.Flush()
+
+	// Writes to a bytes.Buffer can't fail, but the writer reports them here.
+	if err := w.Error(); err != nil {
+		return serverError(ctx, &categorizedError{category: categorySerialization, err: err})
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "text/csv; charset=utf-8; header=present",
+		},
+		Body: buf.String(),
+	}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestListAsCSV(t *testing.T) {
+	setFor(t, &allowedOrigins, parseAllowedOrigins("https://app.example.com"))
+
+	store := newMemoryStore()
+	todo, err := store.Insert(context.Background(), CreateTodo{Task: `buy milk, eggs and "good" bread`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := serve(t, newHandler(store), request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers:    map[string]string{"Accept": "text/csv", "Origin": "https://app.example.com"},
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+	if got := res.Headers["Content-Type"]; !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := res.Headers["Vary"]; got != "Accept, Origin" {
+		t.Errorf("Vary = %q, want %q", got, "Accept, Origin")
+	}
+	if !strings.Contains(res.Body, `"buy milk, eggs and ""good"" bread"`) {
+		t.Errorf("task isn't quoted per RFC 4180: %q", res.Body)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(res.Body)).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing %q: %v", res.Body, err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Fatalf("records = %q, want the header and one todo", records)
+	}
+	if records[1][0] != todo.Id || records[1][1] != todo.Task || records[1][2] != "false" {
+		t.Errorf("row = %q, want %s, %q, false", records[1], todo.Id, todo.Task)
+	}
+}
+
+func TestPrefersCSV(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "application/json", want: false},
+		{accept: "text/csv", want: true},
+		{accept: "application/json, text/csv;q=0.5", want: false},
+		{accept: "application/json;q=0.5, text/csv", want: true},
+		{accept: "text/csv;q=0", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := prefersCSV(tt.accept); got != tt.want {
+			t.Errorf("prefersCSV(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCSVListIsCappedLikeExports(t *testing.T) {
+	setFor(t, &maxPageSize, 2)
+	// Any page goes over, so each response holds a single page.
+	setFor(t, &exportMaxBytes, 1)
+
+	store := newMemoryStore()
+	want := make(map[string]bool)
+	for range 5 {
+		todo, err := store.Insert(context.Background(), CreateTodo{Task: "write tests"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[todo.Id] = true
+	}
+	h := newHandler(store)
+
+	query := map[string]string{}
+	seen := make(map[string]bool)
+	for parts := 1; ; parts++ {
+		if parts > 3 {
+			t.Fatalf("more than 3 parts of 2 for 5 todos")
+		}
+
+		res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: query, Headers: map[string]string{"Accept": "text/csv"}})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+		}
+		records, err := csv.NewReader(strings.NewReader(res.Body)).ReadAll()
+		if err != nil {
+			t.Fatalf("parsing %q: %v", res.Body, err)
+		}
+		for _, record := range records[1:] {
+			if seen[record[0]] {
+				t.Errorf("todo %s listed twice", record[0])
+			}
+			seen[record[0]] = true
+		}
+
+		link := res.Headers["Link"]
+		if link == "" {
+			if res.Headers["X-Export-Truncated"] != "" {
+				t.Errorf("last part is marked truncated")
+			}
+			break
+		}
+		if res.Headers["X-Export-Truncated"] != "true" || len(records) != 3 {
+			t.Errorf("part %d: X-Export-Truncated = %q with %d rows, want a truncated page of 2", parts, res.Headers["X-Export-Truncated"], len(records)-1)
+		}
+
+		next, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(link, "<"), ">; rel=\"next\""))
+		if err != nil {
+			t.Fatalf("parsing Link %q: %v", link, err)
+		}
+		query = map[string]string{"cursor": next.Query().Get("cursor")}
+	}
+
+	if !maps.Equal(seen, want) {
+		t.Errorf("listed %v, want %v", seen, want)
+	}
+}
@@ -2,61 +2,229 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/smithy-go"
+	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/google/uuid"
 )
 
-const TableName = "Todos"
+// IdempotencyTableName stores the Idempotency-Key of recent creates. Its
+// expiresAt attribute is configured as the table's TTL.
+const IdempotencyTableName = "TodoIdempotency"
+
+// HistoryTableName stores the HistoryEntry records of every todo, keyed by
+// todoId and seq.
+const HistoryTableName = "TodoHistory"
+
+// ownerIndexName is the Todos GSI keyed by owner, which List queries when the
+// caller is authenticated.
+const ownerIndexName = "owner-index"
+
+// positionIndexName is the Todos GSI keyed by list and sorted by position,
+// which List queries for todos in position order.
+const positionIndexName = "position-index"
+
+// idempotencyWindow is how long a repeated Idempotency-Key returns the
+// originally created todo.
+const idempotencyWindow = 24 * time.Hour
+
+// batchWriteLimit is the maximum number of requests DynamoDB accepts in a
+// single BatchWriteItem call.
+const batchWriteLimit = 25
+
+// operationTimeout bounds a single DynamoDB operation, retries included, so a
+// hanging call fails well before the Lambda timeout.
+var operationTimeout = time.Duration(envInt("DYNAMODB_TIMEOUT_MS", 3000)) * time.Millisecond
+
+// maxAttempts bounds how often a throttled or failed DynamoDB call is tried.
+// Retries back off exponentially with jitter.
+var maxAttempts = envInt("DYNAMODB_MAX_ATTEMPTS", 5)
 
-var db dynamodb.Client
+// dynamoEndpoint points the DynamoDB client at another endpoint than AWS's,
+// such as LocalStack or DynamoDB Local at http://localhost:4566, for running
+// the stack offline. Unset, the SDK resolves the regional endpoint.
+var dynamoEndpoint = os.Getenv("DYNAMODB_ENDPOINT")
 
-func init() {
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO())
+// dynamoStore is the TodoStore backed by the todos DynamoDB table.
+type dynamoStore struct {
+	client *dynamodb.Client
+	table  string
+	// newID generates the ids of new todos. Tests swap it for a
+	// deterministic sequence.
+	newID func() string
+}
+
+// newDynamoClient builds the client of a dynamoStore. main builds its store
+// once per container, so warm invocations reuse the client's connections.
+var newDynamoClient = dynamodb.NewFromConfig
+
+// newDynamoStore connects to table in region. An empty region falls back to
+// the SDK's usual lookup.
+func newDynamoStore(table string, region string) *dynamoStore {
+	sdkConfig, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(region),
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = maxAttempts
+			})
+		}),
+	)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("Can't load AWS config", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 
-	db = *dynamodb.NewFromConfig(sdkConfig)
+	// Record a subsegment for every DynamoDB call under the request's segment.
+	awsv2.AWSV2Instrumentor(&sdkConfig.APIOptions)
+	sdkConfig.APIOptions = append(sdkConfig.APIOptions, logRetries, limitDuration, markThrottles)
+	if capacityDebug {
+		sdkConfig.APIOptions = append(sdkConfig.APIOptions, reportCapacity)
+	}
+
+	return &dynamoStore{
+		client: newDynamoClient(sdkConfig, withEndpoint(dynamoEndpoint)),
+		table:  table,
+		newID:  uuid.NewString,
+	}
 }
 
-type Todo struct {
-	Id     string `json:"id" dynamodbav:"id"`
-	Task   string `json:"task" dynamodbav:"task"`
-	Status bool   `json:"status" dynamodbav:"status"`
+// withEndpoint overrides the endpoint of the client with endpoint, if set. A
+// base endpoint is used as given, so local emulators aren't sent the virtual
+// hostnames the SDK would otherwise derive.
+func withEndpoint(endpoint string) func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		if endpoint == "" {
+			return
+		}
+
+		o.BaseEndpoint = aws.String(endpoint)
+	}
+}
+
+// limitDuration gives every DynamoDB operation its own operationTimeout
+// deadline. Store methods that make several calls get a fresh one per call.
+func limitDuration(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OperationTimeout",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+			defer cancel()
+
+			return next.HandleInitialize(ctx, in)
+		},
+	), middleware.Before)
+}
+
+// markThrottles wraps the error of an operation DynamoDB kept throttling
+// after all retries in ErrThrottled.
+func markThrottles(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("MarkThrottles",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if err != nil && retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+				err = fmt.Errorf("%w: %w", ErrThrottled, err)
+			}
+
+			return out, metadata, err
+		},
+	), middleware.Before)
 }
 
-func getItem(ctx context.Context, id string) (*Todo, error) {
+type attemptKey struct{}
+
+// logRetries warns about every attempt after the first one the SDK's retryer
+// makes, which would otherwise go unnoticed.
+func logRetries(stack *middleware.Stack) error {
+	err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountAttempts",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			return next.HandleInitialize(context.WithValue(ctx, attemptKey{}, new(int)), in)
+		},
+	), middleware.Before)
+	if err != nil {
+		return err
+	}
+
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("LogRetries",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			if attempt, ok := ctx.Value(attemptKey{}).(*int); ok {
+				*attempt++
+				if *attempt > 1 {
+					logger.WarnContext(ctx, "Retrying DynamoDB call",
+						slog.String("operation", awsmiddleware.GetOperationName(ctx)),
+						slog.Int("attempt", *attempt),
+					)
+				}
+			}
+
+			return next.HandleFinalize(ctx, in)
+		},
+	), "Retry", middleware.After)
+}
+
+func (s *dynamoStore) Ping(ctx context.Context) error {
+	input := &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.table),
+	}
+
+	_, err := s.client.DescribeTable(ctx, input)
+	return err
+}
+
+func (s *dynamoStore) Get(ctx context.Context, id string, opts GetOptions) (*Todo, error) {
 	key, err := attributevalue.Marshal(id)
 	if err != nil {
 		return nil, err
 	}
 
 	input := &dynamodb.GetItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(s.table),
 		Key: map[string]types.AttributeValue{
 			"id": key,
 		},
+		ConsistentRead: aws.Bool(opts.Consistent),
+	}
+
+	owner := subjectFrom(ctx)
+	if fields := opts.Fields; len(fields) > 0 {
+		if owner != "" {
+			fields = append(fields[:len(fields):len(fields)], "owner")
+		}
+
+		expr, err := expression.NewBuilder().WithProjection(projection(fields)).Build()
+		if err != nil {
+			return nil, err
+		}
+
+		input.ProjectionExpression = expr.Projection()
+		input.ExpressionAttributeNames = expr.Names()
 	}
 
-	log.Printf("Calling Dynamodb with input: %v", input)
-	result, err := db.GetItem(ctx, input)
+	logger.InfoContext(ctx, "Calling DynamoDB GetItem", slog.Any("input", input))
+	result, err := s.client.GetItem(ctx, input)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Executed GetItem DynamoDb successfully. Result: %#v", result)
+	logger.InfoContext(ctx, "Executed DynamoDB GetItem successfully", slog.Bool("found", result.Item != nil))
 
 	if result.Item == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	todo := new(Todo)
@@ -65,59 +233,431 @@ func getItem(ctx context.Context, id string) (*Todo, error) {
 		return nil, err
 	}
 
+	// Other owners' todos are reported missing, so ids can't be probed.
+	if owner != "" && todo.Owner != owner {
+		return nil, ErrNotFound
+	}
+
 	return todo, nil
 }
 
-func listItems(ctx context.Context) ([]Todo, error) {
+// List scans the table, or queries the owner index for an authenticated
+// caller and the position index for their list in position order.
+func (s *dynamoStore) List(ctx context.Context, opts ListOptions) ([]Todo, string, error) {
+	owner := subjectFrom(ctx)
+
+	var limit *int32
+	if opts.Limit > 0 {
+		limit = aws.Int32(int32(opts.Limit))
+	}
+
+	filters := []expression.ConditionBuilder{notExpired()}
+	if !opts.IncludeDeleted {
+		filters = append(filters, notDeleted())
+	}
+
+	if opts.Status != nil {
+		filters = append(filters, expression.Equal(
+			expression.Name("status"),
+			expression.Value(*opts.Status),
+		))
+	}
+
+	filter := filters[0]
+	for _, f := range filters[1:] {
+		filter = filter.And(f)
+	}
+
+	builder := expression.NewBuilder().WithFilter(filter)
+	if len(opts.Fields) > 0 {
+		builder = builder.WithProjection(projection(opts.Fields))
+	}
+	index := ownerIndexName
+	switch {
+	case opts.ByPosition:
+		index = positionIndexName
+		key := expression.Key("list").Equal(expression.Value(listOf(owner)))
+		if opts.AfterPosition != nil {
+			key = key.And(expression.Key("position").GreaterThan(expression.Value(*opts.AfterPosition)))
+		}
+		builder = builder.WithKeyCondition(key)
+	case owner != "":
+		builder = builder.WithKeyCondition(expression.Key("owner").Equal(expression.Value(owner)))
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var startKey map[string]types.AttributeValue
+	switch {
+	case opts.Cursor == "":
+	case opts.ByPosition:
+		startKey, err = decodePositionCursor(opts.Cursor, listOf(owner))
+	default:
+		startKey, err = decodeCursor(opts.Cursor, owner)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	if owner == "" && !opts.ByPosition {
+		result, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:                 aws.String(s.table),
+			Limit:                     limit,
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         startKey,
+			ConsistentRead:            aws.Bool(opts.Consistent),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		items, lastKey = result.Items, result.LastEvaluatedKey
+	} else {
+		result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(s.table),
+			IndexName:                 aws.String(index),
+			Limit:                     limit,
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         startKey,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		items, lastKey = result.Items, result.LastEvaluatedKey
+	}
+
 	todos := make([]Todo, 0)
+	err = attributevalue.UnmarshalListOfMaps(items, &todos)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(lastKey) > 0 {
+		nextCursor, err = encodeCursor(lastKey)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return todos, nextCursor, nil
+}
+
+// projection reads only fields. Todo attributes are named like their JSON
+// fields.
+func projection(fields []string) expression.ProjectionBuilder {
+	names := make([]expression.NameBuilder, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, expression.Name(field))
+	}
+
+	return expression.NamesList(names[0], names[1:]...)
+}
+
+// cursorTTL is how long a page cursor returned by List stays usable.
+const cursorTTL = time.Hour
+
+// pageCursor is the JSON behind a List cursor. Scans resume from the
+// LastEvaluatedKey itself, so items inserted between two pages can't shift
+// the page boundaries. Position holds the numeric sort key of cursors of the
+// position index, Key the string attributes.
+type pageCursor struct {
+	Key       map[string]string `json:"k"`
+	Position  *float64          `json:"p,omitempty"`
+	ExpiresAt int64             `json:"e"`
+}
+
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	var cursor pageCursor
+	if position, ok := key["position"]; ok {
+		cursor.Position = new(float64)
+		err := attributevalue.Unmarshal(position, cursor.Position)
+		if err != nil {
+			return "", err
+		}
+
+		key = maps.Clone(key)
+		delete(key, "position")
+	}
+
+	err := attributevalue.UnmarshalMap(key, &cursor.Key)
+	if err != nil {
+		return "", err
+	}
+	cursor.ExpiresAt = time.Now().Add(cursorTTL).Unix()
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor turns a cursor back into an ExclusiveStartKey. Anything but an
+// unexpired cursor holding exactly the key of the table, or of the owner index
+// for owner, fails with ErrInvalidCursor.
+func decodeCursor(value string, owner string) (map[string]types.AttributeValue, error) {
+	cursor, err := parseCursor(value)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := 1
+	if owner != "" {
+		keys = 2
+		if cursor.Key["owner"] != owner {
+			return nil, fmt.Errorf("%w: unexpected owner", ErrInvalidCursor)
+		}
+	}
+
+	if len(cursor.Key) != keys || cursor.Position != nil {
+		return nil, fmt.Errorf("%w: unexpected key", ErrInvalidCursor)
+	}
+
+	return attributevalue.MarshalMap(cursor.Key)
+}
+
+// decodePositionCursor is decodeCursor for the position index, whose cursors
+// hold an id, the list and a position.
+func decodePositionCursor(value string, list string) (map[string]types.AttributeValue, error) {
+	cursor, err := parseCursor(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.Key["list"] != list {
+		return nil, fmt.Errorf("%w: unexpected list", ErrInvalidCursor)
+	}
+
+	if len(cursor.Key) != 2 || cursor.Position == nil {
+		return nil, fmt.Errorf("%w: unexpected key", ErrInvalidCursor)
+	}
+
+	key, err := attributevalue.MarshalMap(cursor.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	key["position"], err = attributevalue.Marshal(*cursor.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// parseCursor decodes an unexpired cursor with a valid id.
+func parseCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	if time.Now().Unix() > cursor.ExpiresAt {
+		return cursor, fmt.Errorf("%w: expired", ErrInvalidCursor)
+	}
+
+	id, ok := cursor.Key["id"]
+	if !ok {
+		return cursor, fmt.Errorf("%w: unexpected key", ErrInvalidCursor)
+	}
+
+	if err := validateID(id); err != nil {
+		return cursor, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	return cursor, nil
+}
+
+// Count returns how many todos exist and how many of them are completed,
+// ignoring soft deleted ones. The status attribute is a boolean, which can't be
+// a GSI key, so both numbers come from Select=COUNT scans. These still read
+// the whole table but don't transfer any items.
+func (s *dynamoStore) Count(ctx context.Context) (int, int, error) {
+	total, err := s.count(ctx, ownedBy(ctx, notDeleted().And(notExpired())))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	completed, err := s.count(ctx, ownedBy(ctx, notDeleted().And(notExpired(), expression.Equal(
+		expression.Name("status"),
+		expression.Value(true),
+	))))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return total, completed, nil
+}
+
+// Search scans for todos whose task contains the query, ignoring case. Todos
+// written before taskLower existed never match.
+//
+// DynamoDB applies the filter after reading a scan page, so a single Scan can
+// return any number of matches, none included. Search keeps scanning until
+// it has opts.Limit matches or reaches the end of the table. When the page
+// fills up partway through a scan page, the cursor points at the last match
+// rather than the LastEvaluatedKey, so the matches after it aren't skipped.
+// Scans of the table resume from any of its keys, which for an owner are
+// still filtered down to their todos.
+func (s *dynamoStore) Search(ctx context.Context, opts SearchOptions) ([]Todo, string, error) {
+	filter := ownedBy(ctx, notDeleted().And(notExpired(), expression.Contains(
+		expression.Name("taskLower"),
+		strings.ToLower(opts.Query),
+	)))
+
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return nil, "", err
+	}
+
 	var token map[string]types.AttributeValue
+	if opts.Cursor != "" {
+		token, err = decodeCursor(opts.Cursor, "")
+		if err != nil {
+			return nil, "", err
+		}
+	}
 
+	todos := make([]Todo, 0)
 	for {
-		input := &dynamodb.ScanInput{
-			TableName:         aws.String(TableName),
-			ExclusiveStartKey: token,
+		result, err := s.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:                 aws.String(s.table),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         token,
+		})
+		if err != nil {
+			return nil, "", err
 		}
 
-		result, err := db.Scan(ctx, input)
+		var page []Todo
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
+		todos = append(todos, page...)
 
-		var fetchedTodos []Todo
-		err = attributevalue.UnmarshalListOfMaps(result.Items, &fetchedTodos)
+		if opts.Limit > 0 && len(todos) >= opts.Limit {
+			if len(todos) == opts.Limit && len(result.LastEvaluatedKey) == 0 {
+				return todos, "", nil
+			}
+
+			todos = todos[:opts.Limit]
+			cursor, err := encodeCursor(map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: todos[opts.Limit-1].Id},
+			})
+
+			return todos, cursor, err
+		}
+
+		token = result.LastEvaluatedKey
+		if len(token) == 0 {
+			return todos, "", nil
+		}
+	}
+}
+
+func (s *dynamoStore) count(ctx context.Context, filter expression.ConditionBuilder) (int, error) {
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var token map[string]types.AttributeValue
+
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:                 aws.String(s.table),
+			Select:                    types.SelectCount,
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         token,
+		}
+
+		result, err := s.client.Scan(ctx, input)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 
-		todos = append(todos, fetchedTodos...)
+		count += int(result.Count)
 		token = result.LastEvaluatedKey
 		if token == nil {
 			break
 		}
 	}
 
-	return todos, nil
+	return count, nil
 }
 
-func insertItem(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
-	todo := Todo{
-		Task:   createTodo.Task,
-		Status: false,
-		Id:     uuid.NewString(),
-	}
+// Insert writes the todo only if its id is still free, which guards
+// client-chosen ids and costs nothing for generated ones.
+func (s *dynamoStore) Insert(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
+	todo := newTodo(ctx, createTodo, s.newID)
 
 	item, err := attributevalue.MarshalMap(todo)
 	if err != nil {
 		return nil, err
 	}
 
+	if uniqueTasks {
+		err = s.transactUnique(ctx, todo, []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:                aws.String(s.table),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+					ExpressionAttributeNames: map[string]string{"#id": "id"},
+				},
+			},
+		})
+		if onlyConditionFailed(err, 0) {
+			return nil, ErrDuplicateID
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &todo, nil
+	}
+
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
-		Item:      item,
+		TableName:                aws.String(s.table),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": "id"},
 	}
 
-	res, err := db.PutItem(ctx, input)
+	res, err := s.client.PutItem(ctx, input)
 	if err != nil {
+		var condCheckFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &condCheckFailed) {
+			return nil, ErrDuplicateID
+		}
+
 		return nil, err
 	}
 
@@ -129,27 +669,222 @@ func insertItem(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
 	return &todo, nil
 }
 
-func deleteItem(ctx context.Context, id string) (*Todo, error) {
+type idempotencyRecord struct {
+	Key       string `dynamodbav:"key"`
+	TodoId    string `dynamodbav:"todoId"`
+	ExpiresAt int64  `dynamodbav:"expiresAt"`
+}
+
+// InsertIdempotent creates a todo unless key was already used within the
+// idempotency window, in which case it returns the todo created back then and
+// false. The todo and the key are written in one transaction so a retry can
+// never observe one without the other.
+func (s *dynamoStore) InsertIdempotent(ctx context.Context, createTodo CreateTodo, key string) (*Todo, bool, error) {
+	todo := newTodo(ctx, createTodo, s.newID)
+	current := time.Now()
+
+	item, err := attributevalue.MarshalMap(todo)
+	if err != nil {
+		return nil, false, err
+	}
+
+	record, err := attributevalue.MarshalMap(idempotencyRecord{
+		Key:       key,
+		TodoId:    todo.Id,
+		ExpiresAt: current.Add(idempotencyWindow).Unix(),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// TTL deletion lags behind expiry, so expired records count as unused.
+	expr, err := expression.NewBuilder().WithCondition(
+		expression.Or(
+			expression.AttributeNotExists(expression.Name("key")),
+			expression.LessThan(
+				expression.Name("expiresAt"),
+				expression.Value(current.Unix()),
+			),
+		),
+	).Build()
+	if err != nil {
+		return nil, false, err
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:                aws.String(s.table),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+					ExpressionAttributeNames: map[string]string{"#id": "id"},
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:                 aws.String(IdempotencyTableName),
+					Item:                      record,
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+				},
+			},
+		},
+	}
+
+	if uniqueTasks {
+		err = s.transactUnique(ctx, todo, input.TransactItems)
+	} else {
+		_, err = s.client.TransactWriteItems(ctx, input)
+	}
+	if err == nil {
+		return &todo, true, nil
+	}
+
+	// A reused key wins over a taken id: the retry gets the todo it created.
+	codes := cancellationCodes(err)
+	if len(codes) < 2 {
+		return nil, false, err
+	}
+
+	if codes[1] != "ConditionalCheckFailed" {
+		if codes[0] == "ConditionalCheckFailed" {
+			return nil, false, ErrDuplicateID
+		}
+
+		return nil, false, err
+	}
+
+	existing, err := s.getIdempotent(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return existing, false, nil
+}
+
+// cancellationCodes returns the reason code of every item of a canceled
+// transaction, or nil when err didn't cancel one.
+func cancellationCodes(err error) []string {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return nil
+	}
+
+	codes := make([]string, 0, len(canceled.CancellationReasons))
+	for _, reason := range canceled.CancellationReasons {
+		codes = append(codes, aws.ToString(reason.Code))
+	}
+
+	return codes
+}
+
+// getIdempotent returns the todo created with key, or ErrNotFound when it
+// has since been deleted.
+func (s *dynamoStore) getIdempotent(ctx context.Context, key string) (*Todo, error) {
+	keyAttr, err := attributevalue.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(IdempotencyTableName),
+		Key: map[string]types.AttributeValue{
+			"key": keyAttr,
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := s.client.GetItem(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	record := new(idempotencyRecord)
+	err = attributevalue.UnmarshalMap(result.Item, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(ctx, record.TodoId, GetOptions{Consistent: true})
+}
+
+func (s *dynamoStore) BatchInsert(ctx context.Context, createTodos []CreateTodo) ([]Todo, error) {
+	todos := make([]Todo, 0, len(createTodos))
+	for _, createTodo := range createTodos {
+		todos = append(todos, newTodo(ctx, createTodo, s.newID))
+	}
+
+	for start := 0; start < len(todos); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(todos) {
+			end = len(todos)
+		}
+
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, todo := range todos[start:end] {
+			item, err := attributevalue.MarshalMap(todo)
+			if err != nil {
+				return nil, err
+			}
+
+			requests = append(requests, types.WriteRequest{
+				PutRequest: &types.PutRequest{Item: item},
+			})
+		}
+
+		err := s.batchWrite(ctx, requests)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return todos, nil
+}
+
+func (s *dynamoStore) Delete(ctx context.Context, id string) (*Todo, error) {
 	key, err := attributevalue.Marshal(id)
 	if err != nil {
 		return nil, err
 	}
 
+	// The condition makes deleting a missing todo fail instead of silently
+	// succeeding, so ALL_OLD always holds exactly what was removed.
+	expr, err := expression.NewBuilder().WithCondition(
+		ownedBy(ctx, expression.AttributeExists(expression.Name("id"))),
+	).Build()
+	if err != nil {
+		return nil, err
+	}
+
 	input := &dynamodb.DeleteItemInput{
-		TableName: aws.String(TableName),
+		TableName: aws.String(s.table),
 		Key: map[string]types.AttributeValue{
 			"id": key,
 		},
-		ReturnValues: types.ReturnValue(*aws.String("ALL_OLD")),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              types.ReturnValue(*aws.String("ALL_OLD")),
 	}
 
-	res, err := db.DeleteItem(ctx, input)
+	res, err := s.client.DeleteItem(ctx, input)
 	if err != nil {
+		var condCheckFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &condCheckFailed) {
+			return nil, ErrNotFound
+		}
+
 		return nil, err
 	}
 
 	if res.Attributes == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	todo := new(Todo)
@@ -161,23 +896,276 @@ func deleteItem(ctx context.Context, id string) (*Todo, error) {
 	return todo, nil
 }
 
-func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, error) {
-	key, err := attributevalue.Marshal(id)
-	if err != nil {
-		return nil, err
-	}
+// SoftDelete flags the todo with id as deleted, keeping it around so that it
+// can be restored. It returns nil when there is no such todo.
+func (s *dynamoStore) SoftDelete(ctx context.Context, id string) (*Todo, error) {
+	current := now()
 
-	expr, err := expression.NewBuilder().WithUpdate(
+	return s.updateWhere(ctx, id,
 		expression.Set(
-			expression.Name("status"),
-			expression.Value(updateTodo.Status),
+			expression.Name("deleted"),
+			expression.Value(true),
+		).Set(
+			expression.Name("deletedAt"),
+			expression.Value(current),
+		).Set(
+			expression.Name("updatedAt"),
+			expression.Value(current),
+		).Add(
+			expression.Name("version"),
+			expression.Value(1),
+		),
+		expression.AttributeExists(expression.Name("id")).And(notDeleted()),
+	)
+}
+
+// Restore clears the deleted flag set by SoftDelete. It returns ErrNotFound
+// when there is no deleted todo with id.
+func (s *dynamoStore) Restore(ctx context.Context, id string) (*Todo, error) {
+	return s.updateWhere(ctx, id,
+		expression.Set(
+			expression.Name("deleted"),
+			expression.Value(false),
+		).Remove(
+			expression.Name("deletedAt"),
+		).Set(
+			expression.Name("updatedAt"),
+			expression.Value(now()),
+		).Add(
+			expression.Name("version"),
+			expression.Value(1),
 		),
-	).WithCondition(
 		expression.Equal(
-			expression.Name("id"),
-			expression.Value(id),
+			expression.Name("deleted"),
+			expression.Value(true),
 		),
-	).Build()
+	)
+}
+
+// SetPosition moves the todo with id to position, unless it is soft deleted.
+// Setting its list too adds todos from before positions to the position index.
+func (s *dynamoStore) SetPosition(ctx context.Context, id string, position float64) (*Todo, error) {
+	return s.updateWhere(ctx, id,
+		expression.Set(
+			expression.Name("position"),
+			expression.Value(position),
+		).Set(
+			expression.Name("list"),
+			expression.Value(listOf(subjectFrom(ctx))),
+		).Set(
+			expression.Name("updatedAt"),
+			expression.Value(now()),
+		).Add(
+			expression.Name("version"),
+			expression.Value(1),
+		),
+		expression.AttributeExists(expression.Name("id")).And(notDeleted()),
+	)
+}
+
+// ownedBy narrows cond to todos owned by the subject of ctx, if any.
+func ownedBy(ctx context.Context, cond expression.ConditionBuilder) expression.ConditionBuilder {
+	owner := subjectFrom(ctx)
+	if owner == "" {
+		return cond
+	}
+
+	return cond.And(expression.Equal(
+		expression.Name("owner"),
+		expression.Value(owner),
+	))
+}
+
+// notDeleted matches todos that haven't been soft deleted, including the ones
+// written before soft deletes existed.
+func notDeleted() expression.ConditionBuilder {
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name("deleted")),
+		expression.Equal(
+			expression.Name("deleted"),
+			expression.Value(false),
+		),
+	)
+}
+
+// notExpired matches todos without an expiry or whose expiry is still ahead.
+// DynamoDB can take a while to delete items after their TTL passes.
+func notExpired() expression.ConditionBuilder {
+	return expression.Or(
+		expression.AttributeNotExists(expression.Name("expiresAt")),
+		expression.GreaterThan(
+			expression.Name("expiresAt"),
+			expression.Value(time.Now().Unix()),
+		),
+	)
+}
+
+func (s *dynamoStore) UpdateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (*Todo, error) {
+	return s.updateVersion(ctx, id, version, expression.Set(
+		expression.Name("status"),
+		expression.Value(updateTodo.Status),
+	))
+}
+
+// SetStatuses sets the status of every todo in ids with one conditional update
+// each, so a failure only affects its own todo. Versions are bumped but not
+// checked, and soft deleted todos count as missing. The condition also
+// requires the status to differ, so a repeated request, like a double click,
+// doesn't write again.
+func (s *dynamoStore) SetStatuses(ctx context.Context, ids []string, status bool) []StatusChange {
+	changes := make([]StatusChange, 0, len(ids))
+	for _, id := range ids {
+		todo, err := s.updateWhere(ctx, id,
+			expression.Set(
+				expression.Name("status"),
+				expression.Value(status),
+			).Set(
+				expression.Name("updatedAt"),
+				expression.Value(now()),
+			).Add(
+				expression.Name("version"),
+				expression.Value(1),
+			),
+			expression.AttributeExists(expression.Name("id")).And(
+				notDeleted(),
+				expression.NotEqual(expression.Name("status"), expression.Value(status)),
+			),
+		)
+		if errors.Is(err, ErrNotFound) {
+			if current, ok := s.hasStatus(ctx, id, status); ok {
+				changes = append(changes, StatusChange{Id: id, Todo: current, Unchanged: true})
+				continue
+			}
+		}
+		changes = append(changes, StatusChange{Id: id, Todo: todo, Err: err})
+	}
+
+	return changes
+}
+
+// hasStatus tells apart the reasons the update of SetStatuses can fail: it
+// returns the todo with id if it exists and already has status.
+func (s *dynamoStore) hasStatus(ctx context.Context, id string, status bool) (*Todo, bool) {
+	current, err := s.Get(ctx, id, GetOptions{Consistent: true})
+	if err != nil || current.Deleted || current.Status != status {
+		return nil, false
+	}
+
+	return current, true
+}
+
+// toggleAttempts bounds how often Toggle rereads a todo that changed status
+// between its read and its update.
+const toggleAttempts = 3
+
+// Toggle flips the status of the todo with id. DynamoDB can't negate an
+// attribute in an update expression, so it reads the status and writes its
+// opposite on the condition that it still holds. It returns ErrNotFound when
+// there is no such todo, and ErrVersionConflict if the status kept changing.
+func (s *dynamoStore) Toggle(ctx context.Context, id string) (*Todo, error) {
+	for attempt := 0; attempt < toggleAttempts; attempt++ {
+		current, err := s.Get(ctx, id, GetOptions{Fields: []string{"id", "status", "deleted"}, Consistent: true})
+		if err != nil {
+			return nil, err
+		}
+
+		if current.Deleted {
+			return nil, ErrNotFound
+		}
+
+		todo, err := s.updateWhere(ctx, id,
+			expression.Set(
+				expression.Name("status"),
+				expression.Value(!current.Status),
+			).Set(
+				expression.Name("updatedAt"),
+				expression.Value(now()),
+			).Add(
+				expression.Name("version"),
+				expression.Value(1),
+			),
+			expression.AttributeExists(expression.Name("id")).And(notDeleted(), expression.Equal(
+				expression.Name("status"),
+				expression.Value(current.Status),
+			)),
+		)
+		if !errors.Is(err, ErrNotFound) {
+			return todo, err
+		}
+	}
+
+	return nil, ErrVersionConflict
+}
+
+// Patch sets only the fields present in patchTodo. updateVersion adds the
+// version and updatedAt, so the update is never empty.
+func (s *dynamoStore) Patch(ctx context.Context, id string, patchTodo PatchTodo, version int) (*Todo, error) {
+	var update expression.UpdateBuilder
+	if patchTodo.Task != nil {
+		update = update.Set(
+			expression.Name("task"),
+			expression.Value(*patchTodo.Task),
+		).Set(
+			expression.Name("taskLower"),
+			expression.Value(strings.ToLower(*patchTodo.Task)),
+		)
+	}
+
+	if patchTodo.Status != nil {
+		update = update.Set(
+			expression.Name("status"),
+			expression.Value(*patchTodo.Status),
+		)
+	}
+
+	return s.updateVersion(ctx, id, version, update)
+}
+
+// Replace sets task and status in a single update, so readers never see one
+// changed without the other.
+func (s *dynamoStore) Replace(ctx context.Context, id string, replaceTodo ReplaceTodo, version int) (*Todo, error) {
+	return s.updateVersion(ctx, id, version, expression.Set(
+		expression.Name("task"),
+		expression.Value(replaceTodo.Task),
+	).Set(
+		expression.Name("taskLower"),
+		expression.Value(strings.ToLower(replaceTodo.Task)),
+	).Set(
+		expression.Name("status"),
+		expression.Value(replaceTodo.Status),
+	))
+}
+
+// updateVersion applies update only if the stored todo is still at
+// version, bumping the version on success. It returns ErrNotFound when the
+// todo doesn't exist and ErrVersionConflict when it was modified concurrently.
+func (s *dynamoStore) updateVersion(ctx context.Context, id string, version int, update expression.UpdateBuilder) (*Todo, error) {
+	todo, err := s.updateWhere(ctx, id,
+		update.Set(
+			expression.Name("updatedAt"),
+			expression.Value(now()),
+		).Set(
+			expression.Name("version"),
+			expression.Value(version+1),
+		),
+		versionCondition(id, version),
+	)
+	if errors.Is(err, ErrNotFound) {
+		return nil, s.conditionFailure(ctx, id)
+	}
+
+	return todo, err
+}
+
+// updateWhere applies update to the todo with id if cond holds, returning the
+// updated todo, or ErrNotFound when cond doesn't hold.
+func (s *dynamoStore) updateWhere(ctx context.Context, id string, update expression.UpdateBuilder, cond expression.ConditionBuilder) (*Todo, error) {
+	key, err := attributevalue.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(ownedBy(ctx, cond)).Build()
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +1174,7 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 		Key: map[string]types.AttributeValue{
 			"id": key,
 		},
-		TableName:                 aws.String(TableName),
+		TableName:                 aws.String(s.table),
 		UpdateExpression:          expr.Update(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
@@ -194,21 +1182,18 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 		ReturnValues:              types.ReturnValue(*aws.String("ALL_NEW")),
 	}
 
-	res, err := db.UpdateItem(ctx, input)
+	res, err := s.client.UpdateItem(ctx, input)
 	if err != nil {
-		var smErr *smithy.OperationError
-		if errors.As(err, &smErr) {
-			var condCheckFailed *types.ConditionalCheckFailedException
-			if errors.As(err, &condCheckFailed) {
-				return nil, nil
-			}
+		var condCheckFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &condCheckFailed) {
+			return nil, ErrNotFound
 		}
 
 		return nil, err
 	}
 
 	if res.Attributes == nil {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	todo := new(Todo)
@@ -219,3 +1204,211 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 
 	return todo, nil
 }
+
+// versionCondition matches the todo with id at the expected version. Todos
+// written before versioning have no version attribute and count as version 0.
+func versionCondition(id string, version int) expression.ConditionBuilder {
+	exists := expression.Equal(
+		expression.Name("id"),
+		expression.Value(id),
+	)
+
+	if version == 0 {
+		return exists.And(expression.AttributeNotExists(expression.Name("version")))
+	}
+
+	return exists.And(expression.Equal(
+		expression.Name("version"),
+		expression.Value(version),
+	))
+}
+
+// conditionFailure tells apart the two reasons a versioned update can fail:
+// the todo is gone, or someone else updated it first.
+func (s *dynamoStore) conditionFailure(ctx context.Context, id string) error {
+	_, err := s.Get(ctx, id, GetOptions{Consistent: true})
+	if err != nil {
+		return err
+	}
+
+	return ErrVersionConflict
+}
+
+func (s *dynamoStore) DeleteAll(ctx context.Context) (int, error) {
+	builder := expression.NewBuilder().WithProjection(expression.NamesList(expression.Name("id")))
+	if owner := subjectFrom(ctx); owner != "" {
+		builder = builder.WithFilter(expression.Equal(
+			expression.Name("owner"),
+			expression.Value(owner),
+		))
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	var token map[string]types.AttributeValue
+
+	for {
+		input := &dynamodb.ScanInput{
+			TableName:                 aws.String(s.table),
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         token,
+		}
+
+		result, err := s.client.Scan(ctx, input)
+		if err != nil {
+			return deleted, err
+		}
+
+		for start := 0; start < len(result.Items); start += batchWriteLimit {
+			end := start + batchWriteLimit
+			if end > len(result.Items) {
+				end = len(result.Items)
+			}
+
+			requests := make([]types.WriteRequest, 0, end-start)
+			for _, key := range result.Items[start:end] {
+				requests = append(requests, types.WriteRequest{
+					DeleteRequest: &types.DeleteRequest{Key: key},
+				})
+			}
+
+			err = s.batchWrite(ctx, requests)
+			if err != nil {
+				return deleted, err
+			}
+			deleted += len(requests)
+		}
+
+		token = result.LastEvaluatedKey
+		if token == nil {
+			break
+		}
+	}
+
+	return deleted, nil
+}
+
+// batchBackoff is the longest delay before the first resend of unprocessed
+// items. It doubles with every attempt up to batchMaxBackoff.
+var batchBackoff = 50 * time.Millisecond
+
+const batchMaxBackoff = 2 * time.Second
+
+// batchWrite sends requests with BatchWriteItem, resending any items DynamoDB
+// reports as unprocessed until all of them have been written. DynamoDB leaves
+// items unprocessed when it throttles, so resends back off exponentially with
+// full jitter, and after maxAttempts the rest fail with ErrThrottled.
+func (s *dynamoStore) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	pending := map[string][]types.WriteRequest{
+		s.table: requests,
+	}
+
+	for attempt := 1; ; attempt++ {
+		input := &dynamodb.BatchWriteItemInput{
+			RequestItems: pending,
+		}
+
+		res, err := s.client.BatchWriteItem(ctx, input)
+		if err != nil {
+			return err
+		}
+
+		pending = res.UnprocessedItems
+		unprocessed := len(pending[s.table])
+		if unprocessed == 0 {
+			return nil
+		}
+
+		if attempt >= maxAttempts {
+			return fmt.Errorf("%w: %d items unprocessed after %d attempts", ErrThrottled, unprocessed, attempt)
+		}
+
+		delay := min(batchBackoff<<(attempt-1), batchMaxBackoff)
+		delay = rand.N(delay + 1)
+		logger.WarnContext(ctx, "Resending unprocessed items",
+			slog.Int("unprocessed", unprocessed),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("backoff", delay),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *dynamoStore) AppendHistory(ctx context.Context, entry HistoryEntry) error {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(HistoryTableName),
+		Item:      item,
+	}
+
+	_, err = s.client.PutItem(ctx, input)
+	return err
+}
+
+// History queries every entry of the todo with id, oldest first. It keeps
+// reading pages until the end, since a single todo has few entries.
+func (s *dynamoStore) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	builder := expression.NewBuilder().
+		WithKeyCondition(expression.Key("todoId").Equal(expression.Value(id)))
+	if owner := subjectFrom(ctx); owner != "" {
+		builder = builder.WithFilter(expression.Equal(
+			expression.Name("owner"),
+			expression.Value(owner),
+		))
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, 0)
+	var token map[string]types.AttributeValue
+
+	for {
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String(HistoryTableName),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ExclusiveStartKey:         token,
+		}
+
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]HistoryEntry, 0, len(result.Items))
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+
+		token = result.LastEvaluatedKey
+		if token == nil {
+			break
+		}
+	}
+	linkHistory(entries)
+
+	return entries, nil
+}
@@ -0,0 +1,93 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// TestDynamoStoreLifecycle runs dynamoStore against a real table, e.g. of
+// DynamoDB Local:
+//
+//	docker run -p 8000:8000 amazon/dynamodb-local
+//	DYNAMODB_ENDPOINT=http://localhost:8000 AWS_REGION=us-east-1 \
+//	AWS_ACCESS_KEY_ID=local AWS_SECRET_ACCESS_KEY=local \
+//	go test -tags integration -run Lifecycle .
+func TestDynamoStoreLifecycle(t *testing.T) {
+	if dynamoEndpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT isn't set")
+	}
+
+	ctx := context.Background()
+	table := fmt.Sprintf("Todos-%d", time.Now().UnixNano())
+	store := newDynamoStore(table, os.Getenv("AWS_REGION"))
+	if err := store.ensureTable(ctx, tableDefinitions(table)[0]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	todo, err := store.Insert(ctx, CreateTodo{Task: "write tests"})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+
+	got, err := store.Get(ctx, todo.Id, GetOptions{Consistent: true})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Task != "write tests" || got.Version != 1 {
+		t.Errorf("Get = %+v, want the inserted todo at version 1", got)
+	}
+
+	for _, task := range []string{"write more tests", "write docs"} {
+		if _, err := store.Insert(ctx, CreateTodo{Task: task}); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+	seen := make(map[string]bool)
+	pages := 0
+	for cursor := ""; pages == 0 || cursor != ""; pages++ {
+		var todos []Todo
+		todos, cursor, err = store.List(ctx, ListOptions{Limit: 2, Cursor: cursor, Consistent: true})
+		if err != nil {
+			t.Fatalf("List page %d: %v", pages, err)
+		}
+		for _, todo := range todos {
+			if seen[todo.Id] {
+				t.Errorf("List returned %s twice", todo.Id)
+			}
+			seen[todo.Id] = true
+		}
+	}
+	if len(seen) != 3 || pages < 2 {
+		t.Errorf("List returned %d todos in %d pages, want 3 in several", len(seen), pages)
+	}
+
+	updated, err := store.UpdateStatus(ctx, todo.Id, UpdateTodo{Status: true}, 1)
+	if err != nil {
+		t.Fatalf("UpdateStatus: %v", err)
+	}
+	if !updated.Status || updated.Version != 2 {
+		t.Errorf("UpdateStatus = %+v, want completed at version 2", updated)
+	}
+	if _, err := store.UpdateStatus(ctx, todo.Id, UpdateTodo{Status: false}, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("UpdateStatus with a stale version = %v, want ErrConflict", err)
+	}
+
+	if _, err := store.Delete(ctx, todo.Id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, todo.Id, GetOptions{Consistent: true}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
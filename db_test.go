@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoError is returned by a fakeDynamo handler to fail the call with a
+// DynamoDB error of that type, e.g. ConditionalCheckFailedException.
+type dynamoError struct {
+	Type    string
+	Message string
+	// Reasons are the cancellation reason codes of a
+	// TransactionCanceledException, one per item.
+	Reasons []string
+}
+
+// fakeDynamo speaks the DynamoDB JSON protocol to a dynamoStore, answering
+// every call with handle, which gets the operation, e.g. "PutItem", and its
+// decoded input. Each call is recorded.
+type fakeDynamo struct {
+	mu     sync.Mutex
+	calls  []dynamoCall
+	handle func(op string, input map[string]any) any
+}
+
+type dynamoCall struct {
+	Op    string
+	Input map[string]any
+}
+
+// newFakeDynamo returns a dynamoStore for the Todos table talking to a fake
+// DynamoDB endpoint.
+func newFakeDynamo(t *testing.T, handle func(op string, input map[string]any) any) (*dynamoStore, *fakeDynamo) {
+	t.Helper()
+
+	fake := &fakeDynamo{handle: handle}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	setFor(t, &dynamoEndpoint, srv.URL)
+
+	return newDynamoStore("Todos", "us-east-1"), fake
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+	var input map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, dynamoCall{Op: op, Input: input})
+	f.mu.Unlock()
+
+	out := f.handle(op, input)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if e, ok := out.(dynamoError); ok {
+		w.WriteHeader(http.StatusBadRequest)
+		body := map[string]any{
+			"__type":  "com.amazonaws.dynamodb.v20120810#" + e.Type,
+			"message": e.Message,
+		}
+		if e.Reasons != nil {
+			reasons := make([]map[string]string, 0, len(e.Reasons))
+			for _, code := range e.Reasons {
+				reasons = append(reasons, map[string]string{"Code": code})
+			}
+			body["CancellationReasons"] = reasons
+		}
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
+
+// callsTo returns the recorded calls of op.
+func (f *fakeDynamo) callsTo(op string) []dynamoCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []dynamoCall
+	for _, call := range f.calls {
+		if call.Op == op {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// fakeTable is a table behind a fakeDynamo, keeping its items in id order.
+type fakeTable struct {
+	items []map[string]any
+}
+
+func (ft *fakeTable) put(id, task string) {
+	ft.items = append(ft.items, map[string]any{
+		"id":   map[string]any{"S": id},
+		"task": map[string]any{"S": task},
+	})
+	slices.SortFunc(ft.items, func(a, b map[string]any) int {
+		return strings.Compare(itemID(a), itemID(b))
+	})
+}
+
+// scan answers a Scan like DynamoDB does, with a page of at most Limit items
+// following ExclusiveStartKey. Filters are ignored.
+func (ft *fakeTable) scan(input map[string]any) map[string]any {
+	var items []map[string]any
+	for _, item := range ft.items {
+		if start, ok := input["ExclusiveStartKey"].(map[string]any); ok && itemID(item) <= itemID(start) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	out := map[string]any{}
+	if limit, ok := input["Limit"].(float64); ok && len(items) > int(limit) {
+		items = items[:int(limit)]
+		out["LastEvaluatedKey"] = map[string]any{"id": items[len(items)-1]["id"]}
+	}
+	out["Items"] = items
+	out["Count"] = len(items)
+
+	return out
+}
+
+func itemID(item map[string]any) string {
+	id, _ := item["id"].(map[string]any)
+	s, _ := id["S"].(string)
+
+	return s
+}
+
+func putRequest(id string) types.WriteRequest {
+	return types.WriteRequest{PutRequest: &types.PutRequest{
+		Item: map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	}}
+}
+
+func TestBatchWriteResendsUnprocessedItems(t *testing.T) {
+	setFor(t, &batchBackoff, time.Millisecond)
+
+	throttled := true
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		if throttled {
+			throttled = false
+			return map[string]any{"UnprocessedItems": input["RequestItems"]}
+		}
+
+		return map[string]any{}
+	})
+
+	err := store.batchWrite(context.Background(), []types.WriteRequest{putRequest("a"), putRequest("b")})
+	if err != nil {
+		t.Fatalf("batchWrite: %v", err)
+	}
+
+	if calls := fake.callsTo("BatchWriteItem"); len(calls) != 2 {
+		t.Errorf("BatchWriteItem called %d times, want 2", len(calls))
+	}
+}
+
+func TestBatchWriteGivesUpWhenThrottled(t *testing.T) {
+	setFor(t, &batchBackoff, time.Millisecond)
+	setFor(t, &maxAttempts, 3)
+
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{"UnprocessedItems": input["RequestItems"]}
+	})
+
+	err := store.batchWrite(context.Background(), []types.WriteRequest{putRequest("a")})
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("batchWrite = %v, want ErrThrottled", err)
+	}
+
+	if calls := fake.callsTo("BatchWriteItem"); len(calls) != 3 {
+		t.Errorf("BatchWriteItem called %d times, want 3", len(calls))
+	}
+}
+
+func TestListCursorIsStableUnderInserts(t *testing.T) {
+	table := &fakeTable{}
+	for _, id := range []string{"20000000", "40000000", "60000000", "80000000"} {
+		table.put(id+"-0000-4000-8000-000000000000", "task "+id)
+	}
+	store, _ := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return table.scan(input)
+	})
+	ctx := context.Background()
+
+	first, cursor, err := store.List(ctx, ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == "" {
+		t.Fatal("first page has no cursor")
+	}
+
+	// One insert lands on the page already read, one on the page to come.
+	table.put("10000000-0000-4000-8000-000000000000", "before")
+	table.put("70000000-0000-4000-8000-000000000000", "after")
+
+	second, _, err := store.List(ctx, ListOptions{Limit: 3, Cursor: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, todo := range append(first, second...) {
+		if seen[todo.Id] {
+			t.Errorf("todo %s listed twice", todo.Id)
+		}
+		seen[todo.Id] = true
+	}
+	for _, id := range []string{"20000000", "40000000", "60000000", "70000000", "80000000"} {
+		if !seen[id+"-0000-4000-8000-000000000000"] {
+			t.Errorf("todo %s not listed", id)
+		}
+	}
+}
+
+func TestSearchPagesThroughFilteredScans(t *testing.T) {
+	table := &fakeTable{}
+	var want []string
+	for i := range 9 {
+		id := fmt.Sprintf("%d0000000-0000-4000-8000-000000000000", i+1)
+		task := "write docs"
+		if i%3 != 1 {
+			task = "Write Tests"
+			want = append(want, id)
+		}
+		table.put(id, task)
+	}
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		// Scan pages of two items stand in for DynamoDB's 1 MB pages, and, as
+		// in DynamoDB, the filter applies to each page after it is read.
+		input["Limit"] = float64(2)
+		out := table.scan(input)
+
+		var matches []map[string]any
+		for _, item := range out["Items"].([]map[string]any) {
+			task, _ := item["task"].(map[string]any)
+			if strings.Contains(strings.ToLower(task["S"].(string)), "tests") {
+				matches = append(matches, item)
+			}
+		}
+		out["Items"] = matches
+		out["Count"] = len(matches)
+
+		return out
+	})
+	h := newHandler(store)
+
+	// The second scan page holds the second and third match, so the first
+	// search page fills up partway through it.
+	query := map[string]string{"q": "TESTS", "limit": "2"}
+	var got []string
+	var pages int
+	for {
+		pages++
+		if pages > 3 {
+			t.Fatalf("more than 3 pages of 2 for 6 matches, got %v", got)
+		}
+
+		res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task/search", QueryStringParameters: query})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+		}
+		var result SearchResult
+		decodeData(t, res, &result)
+		for _, todo := range result.Items {
+			got = append(got, todo.Id)
+		}
+
+		if result.NextCursor == "" {
+			break
+		}
+		if len(result.Items) != 2 {
+			t.Errorf("page %d has %d matches and a next page, want a full page of 2", pages, len(result.Items))
+		}
+		if !result.Truncated || !strings.Contains(res.Headers["Link"], "q=TESTS") {
+			t.Errorf("page %d: truncated = %t, Link = %q, want the next page of q=TESTS", pages, result.Truncated, res.Headers["Link"])
+		}
+		query["cursor"] = result.NextCursor
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("pages found %v, want %v", got, want)
+	}
+	if scans := fake.callsTo("Scan"); len(scans) <= pages {
+		t.Errorf("%d scans for %d pages, want pages filled from several scans", len(scans), pages)
+	}
+}
+
+func TestDecodeCursorRejectsTamperedCursors(t *testing.T) {
+	valid, err := encodeCursor(map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberS{Value: "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(cursor pageCursor) string {
+		data, err := json.Marshal(cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	future := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		cursor string
+		owner  string
+	}{
+		{name: "not base64", cursor: "not a cursor!"},
+		{name: "not json", cursor: base64.RawURLEncoding.EncodeToString([]byte("{"))},
+		{name: "truncated", cursor: valid[:len(valid)/2]},
+		{name: "expired", cursor: encode(pageCursor{
+			Key:       map[string]string{"id": "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"},
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		})},
+		{name: "extra key", cursor: encode(pageCursor{
+			Key:       map[string]string{"id": "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10", "task": "x"},
+			ExpiresAt: future,
+		})},
+		{name: "invalid id", cursor: encode(pageCursor{
+			Key:       map[string]string{"id": "../../etc"},
+			ExpiresAt: future,
+		})},
+		{name: "other owner", owner: "alice", cursor: encode(pageCursor{
+			Key:       map[string]string{"id": "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10", "owner": "mallory"},
+			ExpiresAt: future,
+		})},
+	}
+
+	if _, err := decodeCursor(valid, ""); err != nil {
+		t.Fatalf("decodeCursor(valid) = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.owner)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor = %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestInvalidCursorIsBadRequest(t *testing.T) {
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{}
+	})
+
+	res := serve(t, newHandler(store), request{
+		HTTPMethod:            "GET",
+		Path:                  "/api/task",
+		QueryStringParameters: map[string]string{"cursor": "bm90IGEgY3Vyc29y"},
+	})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusBadRequest, res.Body)
+	}
+	if calls := fake.callsTo("Scan"); len(calls) > 0 {
+		t.Errorf("Scan called %d times with an invalid cursor", len(calls))
+	}
+}
+
+func TestHungOperationIsGatewayTimeout(t *testing.T) {
+	setFor(t, &operationTimeout, 20*time.Millisecond)
+
+	release := make(chan struct{})
+	store, _ := newFakeDynamo(t, func(op string, input map[string]any) any {
+		<-release
+		return map[string]any{}
+	})
+	t.Cleanup(func() { close(release) })
+
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	start := time.Now()
+	res := serve(t, newHandler(store), request{
+		HTTPMethod:     "GET",
+		Path:           "/api/task/" + id,
+		PathParameters: map[string]string{"id": id},
+	})
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusGatewayTimeout, res.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want about the operation timeout", elapsed)
+	}
+}
+
+func TestOwnerScopesDynamoReads(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		switch op {
+		case "GetItem":
+			return map[string]any{"Item": map[string]any{
+				"id":    map[string]any{"S": id},
+				"task":  map[string]any{"S": "bob's task"},
+				"owner": map[string]any{"S": "bob"},
+			}}
+		default:
+			return map[string]any{"Items": []any{}}
+		}
+	})
+	ctx := withSubject(context.Background(), "alice")
+
+	if _, err := store.Get(ctx, id, GetOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of another owner's todo = %v, want ErrNotFound", err)
+	}
+
+	if _, _, err := store.List(ctx, ListOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	queries := fake.callsTo("Query")
+	if len(queries) != 1 || queries[0].Input["IndexName"] != ownerIndexName {
+		t.Fatalf("List queried %+v, want the owner index", queries)
+	}
+	values, _ := json.Marshal(queries[0].Input["ExpressionAttributeValues"])
+	if !strings.Contains(string(values), `"alice"`) {
+		t.Errorf("owner index queried with %s, want alice", values)
+	}
+	if scans := fake.callsTo("Scan"); len(scans) > 0 {
+		t.Errorf("List scanned the table for an owner")
+	}
+}
+
+func TestUpdateStatusUsesNamePlaceholders(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{"Attributes": map[string]any{
+			"id":      map[string]any{"S": id},
+			"task":    map[string]any{"S": "write tests"},
+			"status":  map[string]any{"BOOL": true},
+			"version": map[string]any{"N": "2"},
+		}}
+	})
+
+	res := serve(t, newHandler(store), request{
+		HTTPMethod:     "PUT",
+		Path:           "/api/task/" + id + "/status",
+		PathParameters: map[string]string{"id": id},
+		Headers:        map[string]string{"If-Match": `"1"`},
+		Body:           `{"status":true}`,
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+
+	updates := fake.callsTo("UpdateItem")
+	if len(updates) != 1 {
+		t.Fatalf("UpdateItem called %d times, want 1", len(updates))
+	}
+	input := updates[0].Input
+
+	// STATUS is a DynamoDB reserved word, so it may only appear behind a
+	// #placeholder of ExpressionAttributeNames.
+	var placeholder string
+	names, _ := input["ExpressionAttributeNames"].(map[string]any)
+	for key, name := range names {
+		if name == "status" {
+			placeholder = key
+		}
+	}
+	if !strings.HasPrefix(placeholder, "#") {
+		t.Fatalf("ExpressionAttributeNames = %v, want a #placeholder for status", names)
+	}
+
+	update, _ := input["UpdateExpression"].(string)
+	if !strings.Contains(update, placeholder+" = :") {
+		t.Errorf("UpdateExpression = %q, want it to set %s from a value placeholder", update, placeholder)
+	}
+	for _, field := range []string{"UpdateExpression", "ConditionExpression"} {
+		expr, _ := input[field].(string)
+		if strings.Contains(strings.ToLower(expr), "status") {
+			t.Errorf("%s = %q names status directly", field, expr)
+		}
+	}
+}
+
+func TestDynamoStoreReportsMissingTodos(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	setFor(t, &maxAttempts, 1)
+
+	failing := false
+	store, _ := newFakeDynamo(t, func(op string, input map[string]any) any {
+		if failing {
+			return dynamoError{Type: "InternalServerError", Message: "boom"}
+		}
+		if op == "GetItem" {
+			return map[string]any{}
+		}
+
+		return dynamoError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+	})
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, id, GetOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing todo = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Delete(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a missing todo = %v, want ErrNotFound", err)
+	}
+	if _, err := store.UpdateStatus(ctx, id, UpdateTodo{Status: true}, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateStatus of a missing todo = %v, want ErrNotFound", err)
+	}
+
+	failing = true
+	if _, err := store.Get(ctx, id, GetOptions{}); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get with DynamoDB failing = %v, want an error other than ErrNotFound", err)
+	}
+}
+
+func TestWithEndpoint(t *testing.T) {
+	var o dynamodb.Options
+	withEndpoint("")(&o)
+	if o.BaseEndpoint != nil {
+		t.Errorf("BaseEndpoint = %q without an override, want the AWS default", *o.BaseEndpoint)
+	}
+
+	withEndpoint("http://localhost:4566")(&o)
+	if o.BaseEndpoint == nil || *o.BaseEndpoint != "http://localhost:4566" {
+		t.Errorf("BaseEndpoint = %v, want the override", o.BaseEndpoint)
+	}
+}
+
+func TestClientUsesEndpointOverride(t *testing.T) {
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{"Table": map[string]any{"TableName": "Todos", "TableStatus": "ACTIVE"}}
+	})
+
+	if err := store.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if calls := fake.callsTo("DescribeTable"); len(calls) != 1 {
+		t.Errorf("override endpoint got %d DescribeTable calls, want 1", len(calls))
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// exportMaxBytes caps the body of GET /api/task/export. API Gateway and
+// Lambda buffer the whole response and reject payloads over 6 MB, so the
+// default leaves room for headers and base64 encoding. An export that would
+// go over stops at a page boundary and links to the rest.
+var exportMaxBytes = envInt("EXPORT_MAX_BYTES", 4<<20)
+
+// processExport writes every todo as newline-delimited JSON, one todo per
+// line, for backups that can be piped into jq or reimported. It pages through
+// List at maxPageSize, so only one page beyond the body is held at a time.
+// When the body reaches exportMaxBytes, the response carries
+// X-Export-Truncated and a Link to the next part, which ?cursor= resumes from.
+func (h *handler) processExport(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	opts := ListOptions{
+		Limit:  maxPageSize,
+		Cursor: req.QueryStringParameters["cursor"],
+	}
+
+	if v, ok := req.QueryStringParameters["includeDeleted"]; ok {
+		if v != "true" && v != "false" {
+			logger.InfoContext(ctx, "Invalid includeDeleted", slog.String("includeDeleted", v), slog.String("error_category", categoryValidation))
+			return clientError(http.StatusBadRequest)
+		}
+		opts.IncludeDeleted = v == "true"
+	}
+	logger.InfoContext(ctx, "Received export request", slog.Any("options", opts))
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	count := 0
+	for {
+		page, cursor, err := h.store.List(ctx, opts)
+		if errors.Is(err, ErrInvalidCursor) {
+			logger.InfoContext(ctx, "Invalid cursor", slog.String("cursor", opts.Cursor), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+			return clientErrorMessage(http.StatusBadRequest, "invalid or expired cursor")
+		}
+		if err != nil {
+			return serverError(ctx, err)
+		}
+
+		for _, todo := range page {
+			if err := enc.Encode(todo); err != nil {
+				return serverError(ctx, &categorizedError{category: categorySerialization, err: err})
+			}
+		}
+		count += len(page)
+
+		if cursor == "" {
+			break
+		}
+		opts.Cursor = cursor
+
+		if buf.Len() >= exportMaxBytes {
+			logger.WarnContext(ctx, "Truncated export", slog.Int("count", count), slog.Int("bytes", buf.Len()), slog.String("next_cursor", cursor))
+
+			return ndjsonResponse(buf.Bytes(), map[string]string{
+				"X-Export-Truncated": "true",
+				"Link":               fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(ctx, req, cursor)),
+			}), nil
+		}
+	}
+	logger.InfoContext(ctx, "Successfully exported todos", slog.Int("count", count), slog.Int("bytes", buf.Len()))
+
+	return ndjsonResponse(buf.Bytes(), nil), nil
+}
+
+func ndjsonResponse(body []byte, headers map[string]string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: mergeHeaders(map[string]string{
+			"Content-Type": "application/x-ndjson",
+		}, headers),
+		Body: string(body),
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// secondaryRegion names the region of a global table replica of the todos
+// table. When set, reads of single todos and lists that fail against the
+// primary region, retries and the circuit breaker included, are served from
+// the replica instead. Writes always go to the primary region. The function
+// needs read access to the replica, which template.yml doesn't grant.
+var secondaryRegion = os.Getenv("SECONDARY_REGION")
+
+// failoverStore is a TodoStore that falls back to secondary for Get and List
+// when the embedded primary store fails. Everything else goes to the primary
+// only. The replica lags behind the primary, so a fallback read may miss the
+// latest writes.
+type failoverStore struct {
+	TodoStore
+
+	secondary       TodoStore
+	primaryRegion   string
+	secondaryRegion string
+}
+
+func newFailoverStore(primary TodoStore, primaryRegion string, secondary TodoStore, secondaryRegion string) *failoverStore {
+	return &failoverStore{
+		TodoStore:       primary,
+		secondary:       secondary,
+		primaryRegion:   primaryRegion,
+		secondaryRegion: secondaryRegion,
+	}
+}
+
+func (f *failoverStore) Get(ctx context.Context, id string, opts GetOptions) (*Todo, error) {
+	todo, err := f.TodoStore.Get(ctx, id, opts)
+	if !f.failOver(ctx, "Get", err) {
+		markServedBy(ctx, f.primaryRegion, false)
+		return todo, err
+	}
+
+	markServedBy(ctx, f.secondaryRegion, true)
+	return f.secondary.Get(ctx, id, opts)
+}
+
+func (f *failoverStore) List(ctx context.Context, opts ListOptions) ([]Todo, string, error) {
+	todos, cursor, err := f.TodoStore.List(ctx, opts)
+	if !f.failOver(ctx, "List", err) {
+		markServedBy(ctx, f.primaryRegion, false)
+		return todos, cursor, err
+	}
+
+	markServedBy(ctx, f.secondaryRegion, true)
+	return f.secondary.List(ctx, opts)
+}
+
+// failOver reports whether a read that failed with err should be retried
+// against the secondary region, and logs that it is.
+func (f *failoverStore) failOver(ctx context.Context, operation string, err error) bool {
+	if !isStoreFailure(err) || ctx.Err() != nil {
+		return false
+	}
+
+	logger.WarnContext(ctx, "Reading from secondary region",
+		slog.String("operation", operation),
+		slog.String("primary_region", f.primaryRegion),
+		slog.String("secondary_region", f.secondaryRegion),
+		slog.String("error", err.Error()),
+	)
+
+	return true
+}
+
+// servedBy records the region the reads of a request were served from, for
+// the X-Served-By-Region header. A request that fell back once reports the
+// secondary region.
+type servedBy struct {
+	mu     sync.Mutex
+	region string
+}
+
+type servedByKey struct{}
+
+func withServedBy(ctx context.Context) (context.Context, *servedBy) {
+	served := new(servedBy)
+	return context.WithValue(ctx, servedByKey{}, served), served
+}
+
+func markServedBy(ctx context.Context, region string, fallback bool) {
+	served, ok := ctx.Value(servedByKey{}).(*servedBy)
+	if !ok {
+		return
+	}
+
+	served.mu.Lock()
+	defer served.mu.Unlock()
+
+	if served.region == "" || fallback {
+		served.region = region
+	}
+}
+
+func (s *servedBy) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.region
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestReadsFailOverToSecondary(t *testing.T) {
+	setFor(t, &secondaryRegion, "eu-west-1")
+
+	replica := newMemoryStore()
+	todo, err := replica.Insert(context.Background(), CreateTodo{Task: "write tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := newFakeStore()
+	primary.intercept = func(context.Context, string) error { return errors.New("region down") }
+	h := newHandler(newFailoverStore(primary, "us-east-1", replica, "eu-west-1"))
+
+	res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task/" + todo.Id})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+	var got Todo
+	decodeData(t, res, &got)
+	if got.Id != todo.Id {
+		t.Errorf("GET returned %s, want the replica's %s", got.Id, todo.Id)
+	}
+	if region := res.Headers["X-Served-By-Region"]; region != "eu-west-1" {
+		t.Errorf("X-Served-By-Region = %q, want eu-west-1", region)
+	}
+
+	res = serve(t, h, request{HTTPMethod: "GET", Path: "/api/task"})
+	if res.StatusCode != http.StatusOK || res.Headers["X-Served-By-Region"] != "eu-west-1" {
+		t.Errorf("list = %d served by %q, want 200 from eu-west-1", res.StatusCode, res.Headers["X-Served-By-Region"])
+	}
+
+	res = serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"write docs"}`})
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("POST = %d, want %d", res.StatusCode, http.StatusInternalServerError)
+	}
+	if todos, _, _ := replica.List(context.Background(), ListOptions{}); len(todos) != 1 {
+		t.Errorf("replica has %d todos, want the write kept off it", len(todos))
+	}
+}
+
+func TestMissingTodoDoesNotFailOver(t *testing.T) {
+	setFor(t, &secondaryRegion, "eu-west-1")
+
+	replica := newFakeStore()
+	h := newHandler(newFailoverStore(newMemoryStore(), "us-east-1", replica, "eu-west-1"))
+
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task/" + id})
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+	if region := res.Headers["X-Served-By-Region"]; region != "us-east-1" {
+		t.Errorf("X-Served-By-Region = %q, want us-east-1", region)
+	}
+	if len(replica.calls) > 0 {
+		t.Errorf("replica called: %v", replica.calls)
+	}
+}
@@ -1,9 +1,84 @@
 package main
 
 import (
+	"context"
+	"log/slog"
+	"os"
+
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
 func main() {
-	lambda.Start(router)
+	err := setupOtel(context.Background())
+	if err != nil {
+		logger.Error("Can't set up OpenTelemetry", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if bodySchemasErr != nil {
+		logger.Error("Can't load body schemas", slog.String("error", bodySchemasErr.Error()))
+		os.Exit(1)
+	}
+
+	err = checkCORS()
+	if err != nil {
+		logger.Error("Invalid CORS configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if authEnabled && authJWKSURL == "" {
+		logger.Error("AUTH_JWKS_URL must be set when AUTH_ENABLED is true")
+		os.Exit(1)
+	}
+
+	// Without an audience, tokens minted for other APIs of the same issuer
+	// would be accepted.
+	if authEnabled && authAudience == "" {
+		logger.Error("AUTH_AUDIENCE must be set when AUTH_ENABLED is true")
+		os.Exit(1)
+	}
+
+	h := newHandler(newStore())
+	lambda.StartWithOptions(h.handle, lambda.WithEnableSIGTERM(flushOnShutdown))
+}
+
+// newStore picks the TodoStore named by STORAGE. DynamoDB is the default;
+// "memory" keeps todos in the process for running without AWS.
+func newStore() TodoStore {
+	switch storage := os.Getenv("STORAGE"); storage {
+	case "", "dynamodb":
+		table := os.Getenv("TABLE_NAME")
+		if table == "" {
+			logger.Error("TABLE_NAME must be set to the DynamoDB table holding todos")
+			os.Exit(1)
+		}
+
+		region := os.Getenv("AWS_REGION")
+		dynamo := newDynamoStore(table, region)
+		if createTableIfMissing {
+			err := dynamo.ensureTables(context.Background())
+			if err != nil {
+				logger.Error("Can't create missing tables", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		var store TodoStore = dynamo
+		if breakerThreshold > 0 {
+			store = newBreakerStore(store)
+		}
+
+		if secondaryRegion != "" {
+			store = newFailoverStore(store, region, newDynamoStore(table, secondaryRegion), secondaryRegion)
+		}
+
+		return store
+	case "memory":
+		logger.Warn("Using in-memory storage, todos are lost when the process exits")
+		return newMemoryStore()
+	default:
+		logger.Error("Unknown STORAGE", slog.String("storage", storage))
+		os.Exit(1)
+		return nil
+	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func TestMain(m *testing.M) {
+	// Outside Lambda there is no facade segment for the router's subsegment
+	// to join.
+	os.Setenv("AWS_XRAY_SDK_DISABLED", "true")
+
+	os.Exit(m.Run())
+}
+
+// setFor sets a package variable, usually one read from the environment at
+// cold start, until the test ends.
+func setFor[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
+
+// serve routes req through h like a Lambda invocation would.
+func serve(t *testing.T, h *handler, req request) events.APIGatewayProxyResponse {
+	t.Helper()
+
+	res, err := h.router(context.Background(), req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.HTTPMethod, req.Path, err)
+	}
+
+	return res
+}
+
+// decodeData decodes the data of a DataBody response into v.
+func decodeData(t *testing.T, res events.APIGatewayProxyResponse, v any) {
+	t.Helper()
+
+	body := struct {
+		Data any `json:"data"`
+	}{Data: v}
+	if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+		t.Fatalf("decoding %q: %v", res.Body, err)
+	}
+}
+
+// decodeErrorBody decodes the ErrorBody of an error response.
+func decodeErrorBody(t *testing.T, res events.APIGatewayProxyResponse) ErrorDetail {
+	t.Helper()
+
+	var body ErrorBody
+	if err := json.Unmarshal([]byte(res.Body), &body); err != nil {
+		t.Fatalf("decoding %q: %v", res.Body, err)
+	}
+
+	return body.Error
+}
+
+// captureLogs sends what logger logs at level or above to the returned buffer
+// until the test ends.
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	setFor(t, &logger, slog.New(contextHandler{slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level})}))
+
+	return &buf
+}
+
+func TestDynamoClientIsBuiltOnce(t *testing.T) {
+	var builds int
+	setFor(t, &newDynamoClient, func(cfg aws.Config, optFns ...func(*dynamodb.Options)) *dynamodb.Client {
+		builds++
+		return dynamodb.NewFromConfig(cfg, optFns...)
+	})
+	_, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{"Items": []any{}}
+	})
+	// Only count the store of newStore, not the one of newFakeDynamo.
+	builds = 0
+	t.Setenv("STORAGE", "")
+	t.Setenv("TABLE_NAME", "Todos")
+
+	h := newHandler(newStore())
+	payload := []byte(`{"httpMethod":"GET","path":"/api/task"}`)
+	for range 2 {
+		if _, err := h.handle(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if builds != 1 {
+		t.Errorf("client built %d times for two invocations, want 1", builds)
+	}
+	if scans := fake.callsTo("Scan"); len(scans) != 2 {
+		t.Errorf("Scan called %d times, want 2", len(scans))
+	}
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryStore is a TodoStore that keeps todos in process memory, for running
+// the API locally without DynamoDB. It mirrors the conditions dynamoStore
+// writes with, so handlers behave the same against both.
+type memoryStore struct {
+	mu          sync.Mutex
+	todos       map[string]Todo
+	idempotency map[string]idempotencyRecord
+	history     map[string][]HistoryEntry
+	// newID generates the ids of new todos, like dynamoStore.newID.
+	newID func() string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		todos:       make(map[string]Todo),
+		idempotency: make(map[string]idempotencyRecord),
+		history:     make(map[string][]HistoryEntry),
+		newID:       uuid.NewString,
+	}
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Get always returns every field, since there is nothing to save by reading
+// fewer, and every read is consistent.
+func (s *memoryStore) Get(ctx context.Context, id string, opts GetOptions) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil {
+		return nil, ErrNotFound
+	}
+
+	return todo, nil
+}
+
+// get returns a copy of the todo with id, or nil when it doesn't exist or
+// belongs to someone else. Callers hold s.mu.
+func (s *memoryStore) get(ctx context.Context, id string) *Todo {
+	todo, ok := s.todos[id]
+	if !ok || !owns(ctx, todo.Owner) {
+		return nil
+	}
+
+	return &todo
+}
+
+// owns reports whether the subject of ctx may see something owned by owner.
+func owns(ctx context.Context, owner string) bool {
+	subject := subjectFrom(ctx)
+	return subject == "" || owner == subject
+}
+
+// live reports whether todo is visible in lists, like the owner, notDeleted
+// and notExpired filters.
+func live(ctx context.Context, todo Todo, includeDeleted bool) bool {
+	if !owns(ctx, todo.Owner) || (todo.Deleted && !includeDeleted) {
+		return false
+	}
+
+	return todo.ExpiresAt == 0 || todo.ExpiresAt > time.Now().Unix()
+}
+
+// sorted returns every todo ordered by id, which gives List a stable order to
+// page through. Callers hold s.mu.
+func (s *memoryStore) sorted() []Todo {
+	todos := make([]Todo, 0, len(s.todos))
+	for _, todo := range s.todos {
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool { return todos[i].Id < todos[j].Id })
+
+	return todos
+}
+
+// List pages through todos in id order. Unlike a DynamoDB scan, the limit
+// applies after filtering. The cursor is the last returned id.
+func (s *memoryStore) List(ctx context.Context, opts ListOptions) ([]Todo, string, error) {
+	if opts.ByPosition {
+		return s.listByPosition(ctx, opts)
+	}
+
+	var after string
+	if opts.Cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+
+		if err := validateID(string(id)); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+		after = string(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todos := make([]Todo, 0)
+	for _, todo := range s.sorted() {
+		if todo.Id <= after || !live(ctx, todo, opts.IncludeDeleted) {
+			continue
+		}
+
+		if opts.Status != nil && todo.Status != *opts.Status {
+			continue
+		}
+
+		if opts.Limit > 0 && len(todos) == opts.Limit {
+			last := todos[len(todos)-1].Id
+			return todos, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+		}
+
+		todos = append(todos, todo)
+	}
+
+	return todos, "", nil
+}
+
+// positionCursor is the JSON behind a cursor of listByPosition: the position
+// and id of the last todo of the page.
+type positionCursor struct {
+	Position float64 `json:"p"`
+	Id       string  `json:"i"`
+}
+
+func (c positionCursor) before(todo Todo) bool {
+	if c.Position != todo.Position {
+		return c.Position < todo.Position
+	}
+
+	return c.Id < todo.Id
+}
+
+// listByPosition is List in position order, ties broken by id, like a query
+// of the position index.
+func (s *memoryStore) listByPosition(ctx context.Context, opts ListOptions) ([]Todo, string, error) {
+	var after *positionCursor
+	if opts.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+
+		after = new(positionCursor)
+		if err := json.Unmarshal(data, after); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+		if err := validateID(after.Id); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := listOf(subjectFrom(ctx))
+	todos := make([]Todo, 0)
+	for _, todo := range s.todos {
+		if todo.List != list || todo.Position == 0 || !live(ctx, todo, opts.IncludeDeleted) {
+			continue
+		}
+
+		if opts.AfterPosition != nil && todo.Position <= *opts.AfterPosition {
+			continue
+		}
+
+		if after != nil && !after.before(todo) {
+			continue
+		}
+
+		if opts.Status != nil && todo.Status != *opts.Status {
+			continue
+		}
+
+		todos = append(todos, todo)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return positionCursor{Position: todos[i].Position, Id: todos[i].Id}.before(todos[j])
+	})
+
+	if opts.Limit <= 0 || len(todos) <= opts.Limit {
+		return todos, "", nil
+	}
+
+	last := todos[opts.Limit-1]
+	// A positionCursor always marshals.
+	data, _ := json.Marshal(positionCursor{Position: last.Position, Id: last.Id})
+
+	return todos[:opts.Limit], base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (s *memoryStore) Count(ctx context.Context) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, completed := 0, 0
+	for _, todo := range s.todos {
+		if !live(ctx, todo, false) {
+			continue
+		}
+
+		total++
+		if todo.Status {
+			completed++
+		}
+	}
+
+	return total, completed, nil
+}
+
+// Search pages through matching todos in id order, with cursors like List.
+func (s *memoryStore) Search(ctx context.Context, opts SearchOptions) ([]Todo, string, error) {
+	var after string
+	if opts.Cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+
+		if err := validateID(string(id)); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+		}
+		after = string(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := strings.ToLower(opts.Query)
+	todos := make([]Todo, 0)
+	for _, todo := range s.sorted() {
+		if todo.Id <= after || !live(ctx, todo, false) || !strings.Contains(todo.TaskLower, query) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(todos) == opts.Limit {
+			last := todos[len(todos)-1].Id
+			return todos, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+		}
+
+		todos = append(todos, todo)
+	}
+
+	return todos, "", nil
+}
+
+func (s *memoryStore) Insert(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := newTodo(ctx, createTodo, s.newID)
+	if _, ok := s.todos[todo.Id]; ok {
+		return nil, ErrDuplicateID
+	}
+
+	if uniqueTasks && s.taskTaken(todo) {
+		return nil, ErrDuplicateTask
+	}
+
+	s.todos[todo.Id] = todo
+
+	return &todo, nil
+}
+
+func (s *memoryStore) InsertIdempotent(ctx context.Context, createTodo CreateTodo, key string) (*Todo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := time.Now()
+	if record, ok := s.idempotency[key]; ok && record.ExpiresAt >= current.Unix() {
+		todo := s.get(ctx, record.TodoId)
+		if todo == nil {
+			return nil, false, ErrNotFound
+		}
+
+		return todo, false, nil
+	}
+
+	todo := newTodo(ctx, createTodo, s.newID)
+	if _, ok := s.todos[todo.Id]; ok {
+		return nil, false, ErrDuplicateID
+	}
+
+	if uniqueTasks && s.taskTaken(todo) {
+		return nil, false, ErrDuplicateTask
+	}
+
+	s.todos[todo.Id] = todo
+	s.idempotency[key] = idempotencyRecord{
+		Key:       key,
+		TodoId:    todo.Id,
+		ExpiresAt: current.Add(idempotencyWindow).Unix(),
+	}
+
+	return &todo, true, nil
+}
+
+func (s *memoryStore) BatchInsert(ctx context.Context, createTodos []CreateTodo) ([]Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todos := make([]Todo, 0, len(createTodos))
+	for _, createTodo := range createTodos {
+		todo := newTodo(ctx, createTodo, s.newID)
+		s.todos[todo.Id] = todo
+		todos = append(todos, todo)
+	}
+
+	return todos, nil
+}
+
+func (s *memoryStore) UpdateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (*Todo, error) {
+	return s.updateVersion(ctx, id, version, func(todo *Todo) {
+		todo.Status = updateTodo.Status
+	})
+}
+
+func (s *memoryStore) SetStatuses(ctx context.Context, ids []string, status bool) []StatusChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make([]StatusChange, 0, len(ids))
+	for _, id := range ids {
+		todo := s.get(ctx, id)
+		if todo == nil || todo.Deleted {
+			changes = append(changes, StatusChange{Id: id, Err: ErrNotFound})
+			continue
+		}
+
+		if todo.Status == status {
+			changes = append(changes, StatusChange{Id: id, Todo: todo, Unchanged: true})
+			continue
+		}
+
+		todo.Status = status
+		todo.UpdatedAt = now()
+		todo.Version++
+		s.todos[id] = *todo
+		changes = append(changes, StatusChange{Id: id, Todo: todo})
+	}
+
+	return changes
+}
+
+func (s *memoryStore) Toggle(ctx context.Context, id string) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil || todo.Deleted {
+		return nil, ErrNotFound
+	}
+
+	todo.Status = !todo.Status
+	todo.UpdatedAt = now()
+	todo.Version++
+	s.todos[id] = *todo
+
+	return todo, nil
+}
+
+func (s *memoryStore) Patch(ctx context.Context, id string, patchTodo PatchTodo, version int) (*Todo, error) {
+	return s.updateVersion(ctx, id, version, func(todo *Todo) {
+		if patchTodo.Task != nil {
+			todo.Task = *patchTodo.Task
+			todo.TaskLower = strings.ToLower(*patchTodo.Task)
+		}
+
+		if patchTodo.Status != nil {
+			todo.Status = *patchTodo.Status
+		}
+	})
+}
+
+func (s *memoryStore) Replace(ctx context.Context, id string, replaceTodo ReplaceTodo, version int) (*Todo, error) {
+	return s.updateVersion(ctx, id, version, func(todo *Todo) {
+		todo.Task = replaceTodo.Task
+		todo.TaskLower = strings.ToLower(replaceTodo.Task)
+		todo.Status = replaceTodo.Status
+	})
+}
+
+// updateVersion applies change if the todo is still at version, like
+// dynamoStore.updateVersion.
+func (s *memoryStore) updateVersion(ctx context.Context, id string, version int, change func(*Todo)) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil {
+		return nil, ErrNotFound
+	}
+
+	if todo.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	change(todo)
+	todo.UpdatedAt = now()
+	todo.Version = version + 1
+	s.todos[id] = *todo
+
+	return todo, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, id string) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil {
+		return nil, ErrNotFound
+	}
+	delete(s.todos, id)
+
+	return todo, nil
+}
+
+func (s *memoryStore) SoftDelete(ctx context.Context, id string) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil || todo.Deleted {
+		return nil, ErrNotFound
+	}
+
+	current := now()
+	todo.Deleted = true
+	todo.DeletedAt = current
+	todo.UpdatedAt = current
+	todo.Version++
+	s.todos[id] = *todo
+
+	return todo, nil
+}
+
+func (s *memoryStore) Restore(ctx context.Context, id string) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil || !todo.Deleted {
+		return nil, ErrNotFound
+	}
+
+	todo.Deleted = false
+	todo.DeletedAt = ""
+	todo.UpdatedAt = now()
+	todo.Version++
+	s.todos[id] = *todo
+
+	return todo, nil
+}
+
+func (s *memoryStore) SetPosition(ctx context.Context, id string, position float64) (*Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	todo := s.get(ctx, id)
+	if todo == nil || todo.Deleted {
+		return nil, ErrNotFound
+	}
+
+	todo.Position = position
+	todo.List = listOf(subjectFrom(ctx))
+	todo.UpdatedAt = now()
+	todo.Version++
+	s.todos[id] = *todo
+
+	return todo, nil
+}
+
+func (s *memoryStore) DeleteAll(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, todo := range s.todos {
+		if owns(ctx, todo.Owner) {
+			delete(s.todos, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *memoryStore) AppendHistory(ctx context.Context, entry HistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.history[entry.TodoId] = append(s.history[entry.TodoId], entry)
+
+	return nil
+}
+
+func (s *memoryStore) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(s.history[id]))
+	for _, entry := range s.history[id] {
+		if owns(ctx, entry.Owner) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	linkHistory(entries)
+
+	return entries, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/header"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// MetricsNamespace is the CloudWatch namespace the EMF metrics are published under.
+const MetricsNamespace = "TodoApi"
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfMetricDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64                `json:"Timestamp"`
+	CloudWatchMetrics []emfMetricDirective `json:"CloudWatchMetrics"`
+}
+
+// coldStart is true until the first request of this container has been
+// served. Lambda runs one request at a time per container.
+var coldStart = true
+
+// requestMetrics builds an Embedded Metric Format document for a single
+// request. CloudWatch extracts the metrics from the log line on ingestion.
+func requestMetrics(route string, status int, latency time.Duration, coldStart bool) map[string]any {
+	var clientErrors, serverErrors, coldStarts int
+	if coldStart {
+		coldStarts = 1
+	}
+
+	switch {
+	case status >= 500:
+		serverErrors = 1
+	case status >= 400:
+		clientErrors = 1
+	}
+
+	return map[string]any{
+		"_aws": emfMetadata{
+			Timestamp: time.Now().UnixMilli(),
+			CloudWatchMetrics: []emfMetricDirective{
+				{
+					Namespace:  MetricsNamespace,
+					Dimensions: [][]string{{"Route"}},
+					Metrics: []emfMetric{
+						{Name: "Requests", Unit: "Count"},
+						{Name: "Latency", Unit: "Milliseconds"},
+						{Name: "4xx", Unit: "Count"},
+						{Name: "5xx", Unit: "Count"},
+						{Name: "ColdStart", Unit: "Count"},
+					},
+				},
+			},
+		},
+		"Route":      route,
+		"StatusCode": status,
+		"Requests":   1,
+		"Latency":    float64(latency.Microseconds()) / 1000,
+		"4xx":        clientErrors,
+		"5xx":        serverErrors,
+		"ColdStart":  coldStarts,
+	}
+}
+
+// breakerMetrics builds an EMF document recording that the store circuit
+// breaker opened or closed. CircuitOpen is 1 when it opened and 0 when it
+// closed, so its maximum over a period shows whether it was open at all.
+func breakerMetrics(open bool) map[string]any {
+	state := 0
+	if open {
+		state = 1
+	}
+
+	return map[string]any{
+		"_aws": emfMetadata{
+			Timestamp: time.Now().UnixMilli(),
+			CloudWatchMetrics: []emfMetricDirective{
+				{
+					Namespace:  MetricsNamespace,
+					Dimensions: [][]string{{}},
+					Metrics: []emfMetric{
+						{Name: "CircuitOpen", Unit: "None"},
+					},
+				},
+			},
+		},
+		"CircuitOpen": state,
+	}
+}
+
+// metricsOutput is where EMF lines are written. CloudWatch Logs picks them up
+// from stdout.
+var metricsOutput io.Writer = os.Stdout
+
+func emitMetrics(ctx context.Context, doc map[string]any) {
+	// Properties that aren't metrics or dimensions are kept on the log line,
+	// so a metric anomaly can be followed to the traces behind it.
+	if id := traceID(ctx); id != "" {
+		doc["TraceId"] = id
+	}
+
+	line, err := json.Marshal(doc)
+	if err != nil {
+		logger.Error("Can't marshal metrics", slog.String("error", err.Error()))
+		return
+	}
+
+	fmt.Fprintln(metricsOutput, string(line))
+}
+
+// traceID returns the X-Ray trace id of the request, or "" outside of a
+// trace. The segment has it once one has begun; before that, and when the
+// SDK is disabled, it comes from the trace header Lambda puts on the context
+// and in _X_AMZN_TRACE_ID.
+func traceID(ctx context.Context) string {
+	if id := xray.TraceID(ctx); id != "" {
+		return id
+	}
+
+	traceHeader, _ := ctx.Value(xray.LambdaTraceHeaderKey).(string)
+	if traceHeader == "" {
+		traceHeader = os.Getenv("_X_AMZN_TRACE_ID")
+	}
+
+	return header.FromString(traceHeader).TraceID
+}
+
+// metricsEndpoint enables GET /metrics, which serves counters kept by this
+// container in the Prometheus text format. Each Lambda container counts only
+// its own requests, so it is mostly useful locally or in a long-lived
+// container deployment.
+var metricsEndpoint = os.Getenv("METRICS_ENDPOINT") == "true"
+
+// latencyWindow is how many of the most recent latencies the /metrics
+// quantiles are computed from.
+const latencyWindow = 1024
+
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+type containerMetrics struct {
+	mu         sync.Mutex
+	requests   int
+	statuses   map[int]int
+	latencySum float64
+	latencies  []float64
+	next       int
+}
+
+var localMetrics = &containerMetrics{statuses: make(map[int]int)}
+
+func (m *containerMetrics) record(status int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests++
+	m.statuses[status]++
+	m.latencySum += latency.Seconds()
+
+	if len(m.latencies) < latencyWindow {
+		m.latencies = append(m.latencies, latency.Seconds())
+	} else {
+		m.latencies[m.next] = latency.Seconds()
+		m.next = (m.next + 1) % latencyWindow
+	}
+}
+
+// prometheus renders the counters in the Prometheus text exposition format.
+func (m *containerMetrics) prometheus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	fmt.Fprintln(&b, "# HELP todo_requests_total Requests served by this container.")
+	fmt.Fprintln(&b, "# TYPE todo_requests_total counter")
+	fmt.Fprintf(&b, "todo_requests_total %d\n", m.requests)
+
+	codes := make([]int, 0, len(m.statuses))
+	for code := range m.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	fmt.Fprintln(&b, "# HELP todo_responses_total Responses by status code.")
+	fmt.Fprintln(&b, "# TYPE todo_responses_total counter")
+	for _, code := range codes {
+		fmt.Fprintf(&b, "todo_responses_total{code=\"%d\"} %d\n", code, m.statuses[code])
+	}
+
+	sorted := append([]float64(nil), m.latencies...)
+	sort.Float64s(sorted)
+
+	fmt.Fprintf(&b, "# HELP todo_request_duration_seconds Request latency, with quantiles over the last %d requests.\n", latencyWindow)
+	fmt.Fprintln(&b, "# TYPE todo_request_duration_seconds summary")
+	if len(sorted) > 0 {
+		for _, q := range latencyQuantiles {
+			fmt.Fprintf(&b, "todo_request_duration_seconds{quantile=\"%g\"} %g\n", q, sorted[int(q*float64(len(sorted)-1))])
+		}
+	}
+	fmt.Fprintf(&b, "todo_request_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(&b, "todo_request_duration_seconds_count %d\n", m.requests)
+
+	return b.String()
+}
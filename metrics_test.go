@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// captureMetrics sends the EMF lines emitted to the returned buffer until the
+// test ends.
+func captureMetrics(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	setFor(t, &metricsOutput, io.Writer(&buf))
+
+	return &buf
+}
+
+// requestMetricLine returns the EMF line of the request latency metric.
+func requestMetricLine(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var doc map[string]any
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		if _, ok := doc["Latency"]; ok {
+			return doc
+		}
+	}
+
+	t.Fatalf("no request metrics in %q", buf.String())
+	return nil
+}
+
+func TestMetricsCarryTraceID(t *testing.T) {
+	const traceHeader = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	const want = "1-5759e988-bd862e3fe1be46a994272793"
+
+	tests := []struct {
+		name string
+		// ctxHeader is the trace header Lambda puts on the context, envHeader
+		// the one in _X_AMZN_TRACE_ID.
+		ctxHeader string
+		envHeader string
+		want      string
+	}{
+		{name: "context", ctxHeader: traceHeader, want: want},
+		{name: "environment", envHeader: traceHeader, want: want},
+		{name: "no trace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("_X_AMZN_TRACE_ID", tt.envHeader)
+			metrics := captureMetrics(t)
+			logs := captureLogs(t, slog.LevelInfo)
+
+			ctx := context.Background()
+			if tt.ctxHeader != "" {
+				ctx = context.WithValue(ctx, xray.LambdaTraceHeaderKey, tt.ctxHeader)
+			}
+			if _, err := newHandler(newMemoryStore()).router(ctx, request{HTTPMethod: "GET", Path: "/api/task"}); err != nil {
+				t.Fatal(err)
+			}
+
+			doc := requestMetricLine(t, metrics)
+			got, ok := doc["TraceId"]
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("EMF TraceId = %v outside of a trace, want it left out", got)
+			case tt.want != "" && got != tt.want:
+				t.Errorf("EMF TraceId = %v, want %s", got, tt.want)
+			}
+
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("decoding %q: %v", line, err)
+				}
+				got, ok := entry["trace_id"]
+				if tt.want == "" && ok {
+					t.Errorf("%q logged trace_id = %v outside of a trace", entry["msg"], got)
+				}
+				if tt.want != "" && got != tt.want {
+					t.Errorf("%q logged trace_id = %v, want %s", entry["msg"], got, tt.want)
+				}
+			}
+		})
+	}
+}
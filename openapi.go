@@ -0,0 +1,26 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// openAPISpec describes the routes of dispatch and their bodies. It is
+// maintained by hand, so update it along with routes and their types.
+//
+//go:embed openapi.json
+var openAPISpec string
+
+// processOpenAPI serves openAPISpec for generating clients and for Swagger
+// UI. The document is served as is, without the data envelope.
+func (h *handler) processOpenAPI() (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: openAPISpec,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var doc openAPIDocument
+	if err := json.Unmarshal([]byte(openAPISpec), &doc); err != nil {
+		t.Fatalf("openapi.json doesn't parse: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want an OpenAPI 3 document", doc.OpenAPI)
+	}
+
+	for _, template := range routeTemplates {
+		if template == "/metrics" {
+			// Prometheus scrapes it; it isn't part of the API.
+			continue
+		}
+
+		operations, ok := doc.Paths[template]
+		if !ok {
+			t.Errorf("%s isn't documented", template)
+			continue
+		}
+
+		path := strings.ReplaceAll(template, "{id}", "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10")
+		for _, method := range allowedMethods(path) {
+			if method == "OPTIONS" {
+				continue
+			}
+			if _, ok := operations[strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s isn't documented", method, template)
+			}
+		}
+		for method := range operations {
+			if method != "parameters" && !slices.Contains(allowedMethods(path), strings.ToUpper(method)) {
+				t.Errorf("%s %s is documented but not served", strings.ToUpper(method), template)
+			}
+		}
+	}
+
+	for _, ref := range regexp.MustCompile(`"\$ref":\s*"#/components/schemas/([^"]+)"`).FindAllStringSubmatch(openAPISpec, -1) {
+		if _, ok := doc.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("$ref to missing schema %s", ref[1])
+		}
+	}
+	for _, schema := range []string{"Todo", "CreateTodo", "UpdateTodo", "ErrorBody"} {
+		if _, ok := doc.Components.Schemas[schema]; !ok {
+			t.Errorf("schema %s isn't documented", schema)
+		}
+	}
+}
+
+func TestOpenAPISpecIsServed(t *testing.T) {
+	res := serve(t, newHandler(newMemoryStore()), request{HTTPMethod: "GET", Path: "/api/openapi.json"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if got := res.Headers["Content-Type"]; got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if res.Body != openAPISpec {
+		t.Error("served document differs from openapi.json")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const instrumentationName = "github.com/CrazyRoka/todo-app-lambda"
+
+// The global providers are no-ops until setupOtel replaces them, and
+// instruments created from them before that start recording once it does.
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	requestDuration, _ = meter.Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of API requests."),
+	)
+)
+
+// requestCarrier reads the traceparent and tracestate headers of a request,
+// whatever their case.
+type requestCarrier request
+
+func (c requestCarrier) Get(key string) string {
+	return requestHeader(request(c), key)
+}
+
+// Set does nothing, since incoming requests are only read.
+func (c requestCarrier) Set(key string, value string) {}
+
+func (c requestCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.Headers))
+	for key := range c.Headers {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// flushers export telemetry buffered in the process. Lambda freezes the
+// process as soon as an invocation returns, so router runs them at the end of
+// every request rather than relying on background exporters, and main runs
+// them once more on SIGTERM.
+var flushers []func(context.Context)
+
+// registerFlush adds f to the functions flushTelemetry runs.
+func registerFlush(f func(context.Context)) {
+	flushers = append(flushers, f)
+}
+
+func flushTelemetry(ctx context.Context) {
+	for _, flush := range flushers {
+		flush(ctx)
+	}
+}
+
+// shutdownFlushTimeout bounds the flush on SIGTERM. Lambda allows 500ms
+// between SIGTERM and SIGKILL.
+const shutdownFlushTimeout = 400 * time.Millisecond
+
+// flushOnShutdown is the SIGTERM callback passed to lambda.Start. Lambda
+// only sends SIGTERM to functions that have an extension registered.
+func flushOnShutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+	defer cancel()
+
+	logger.InfoContext(ctx, "Flushing telemetry before shutdown")
+	flushTelemetry(ctx)
+}
+
+// setupOtel exports traces and metrics over OTLP/HTTP when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set. The exporters read the endpoint and the
+// rest of the standard OTEL_* variables themselves. W3C trace context is
+// propagated either way, so a trace started upstream carries on through calls
+// this function makes.
+func setupOtel(ctx context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return err
+	}
+
+	metricExporter, err := otlpmetrichttp.New(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The default resource picks up OTEL_SERVICE_NAME and
+	// OTEL_RESOURCE_ATTRIBUTES.
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+	)
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+
+	registerFlush(func(ctx context.Context) {
+		if err := tp.ForceFlush(ctx); err != nil {
+			logger.ErrorContext(ctx, "Can't flush traces", slog.String("error", err.Error()))
+		}
+	})
+	registerFlush(func(ctx context.Context) {
+		if err := mp.ForceFlush(ctx); err != nil {
+			logger.ErrorContext(ctx, "Can't flush metrics", slog.String("error", err.Error()))
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanRecorderOnce sync.Once
+	spanRecorder     *tracetest.SpanRecorder
+)
+
+// recordSpans installs a tracer provider recording the spans of tracer, the
+// way setupOtel installs an exporting one. The global provider can only be
+// delegated to once, so every test shares the recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	return spanRecorder
+}
+
+// lastSpan returns the span ended last.
+func lastSpan(t *testing.T, recorder *tracetest.SpanRecorder) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	spans := recorder.Ended()
+	if len(spans) == 0 {
+		t.Fatal("no span ended")
+	}
+
+	return spans[len(spans)-1]
+}
+
+// countFlushes replaces the registered flushers with one counting its calls.
+func countFlushes(t *testing.T) *int {
+	t.Helper()
+
+	var flushes int
+	setFor(t, &flushers, []func(context.Context){
+		func(context.Context) { flushes++ },
+	})
+
+	return &flushes
+}
+
+func TestTelemetryIsFlushedOncePerInvocation(t *testing.T) {
+	flushes := countFlushes(t)
+
+	store := newFakeStore()
+	h := newHandler(store)
+
+	payload := []byte(`{"httpMethod":"GET","path":"/api/task","requestContext":{"requestId":"r1"}}`)
+	if _, err := h.handle(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if *flushes != 1 {
+		t.Fatalf("flushed %d times after one invocation, want 1", *flushes)
+	}
+
+	// A panicking handler still completes the invocation.
+	store.intercept = func(context.Context, string) error { panic("store exploded") }
+	serve(t, h, request{HTTPMethod: "GET", Path: "/api/task"})
+	if *flushes != 2 {
+		t.Errorf("flushed %d times after two invocations, want 2", *flushes)
+	}
+}
+
+func TestTelemetryIsFlushedOnShutdown(t *testing.T) {
+	flushes := countFlushes(t)
+
+	flushOnShutdown()
+	if *flushes != 1 {
+		t.Errorf("flushed %d times on shutdown, want 1", *flushes)
+	}
+}
+
+func TestSpanJoinsIncomingTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	h := newHandler(newMemoryStore())
+
+	serve(t, h, request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers: map[string]string{
+			"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"tracestate":  "vendor=value",
+		},
+	})
+	span := lastSpan(t, recorder)
+	if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s, want the traceparent's", got)
+	}
+	if got := span.Parent().SpanID().String(); got != "00f067aa0ba902b7" || !span.Parent().IsRemote() {
+		t.Errorf("parent = %s, want the remote span of the traceparent", got)
+	}
+	if got := span.SpanContext().TraceState().Get("vendor"); got != "value" {
+		t.Errorf("tracestate vendor = %q, want value", got)
+	}
+
+	serve(t, h, request{HTTPMethod: "GET", Path: "/api/task"})
+	span = lastSpan(t, recorder)
+	if span.Parent().IsValid() {
+		t.Errorf("span without traceparent has parent %s", span.Parent().SpanID())
+	}
+	if span.SpanContext().TraceID().String() == "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("span without traceparent joined the previous trace")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// MoveTodo is the body of PUT /api/task/{id}/position. The todo is moved
+// right after the todo with id After, or to the top when After is left out.
+type MoveTodo struct {
+	After *string `json:"after" validate:"omitempty,uuid"`
+}
+
+// positionStep is the gap left between a todo moved to the top or bottom of
+// the list and its neighbour.
+const positionStep = 1000
+
+// newPosition puts new todos at the bottom of the list. Positions start out
+// as the creation time in milliseconds, so todos keep their creation order
+// until they are moved.
+func newPosition() float64 {
+	return float64(time.Now().UnixMilli())
+}
+
+// todoPosition is the position of todo. Todos created before positions
+// existed have none and count as placed at their creation time, which is what
+// newPosition would have given them.
+func todoPosition(todo Todo) float64 {
+	if todo.Position != 0 {
+		return todo.Position
+	}
+
+	createdAt, err := time.Parse(timestampLayout, todo.CreatedAt)
+	if err != nil {
+		return 0
+	}
+
+	return float64(createdAt.UnixMilli())
+}
+
+// listOf returns the list the todos of owner are ordered in. Without auth,
+// every todo is in the shared list.
+func listOf(owner string) string {
+	if owner == "" {
+		return "shared"
+	}
+
+	return "owner#" + owner
+}
+
+// errPositionExhausted is returned when two neighbours are so close that no
+// float64 lies between them. Moving one of them elsewhere opens a gap again.
+var errPositionExhausted = errors.New("no position left between the neighbours")
+
+// positionAfter returns the position between the todo with id after and the
+// todo following it, leaving out moving itself. With after empty, it is the
+// position before the first todo. Only the todo after and its successor are
+// read, the latter from the position index.
+func (h *handler) positionAfter(ctx context.Context, moving string, after string) (float64, error) {
+	if after == "" {
+		first, err := h.nextByPosition(ctx, moving, nil)
+		if err != nil || first == nil {
+			return newPosition(), err
+		}
+
+		return first.Position - positionStep, nil
+	}
+
+	todo, err := h.store.Get(ctx, after, GetOptions{Fields: []string{"id", "position", "createdAt", "deleted"}})
+	if err != nil {
+		return 0, err
+	}
+	if todo.Deleted {
+		return 0, ErrNotFound
+	}
+
+	lower := todoPosition(*todo)
+	next, err := h.nextByPosition(ctx, moving, &lower)
+	if err != nil {
+		return 0, err
+	}
+	if next == nil {
+		return lower + positionStep, nil
+	}
+
+	upper := next.Position
+	position := lower + (upper-lower)/2
+	if position <= lower || position >= upper {
+		return 0, errPositionExhausted
+	}
+
+	return position, nil
+}
+
+// nextByPosition returns the first todo in position order after position,
+// or of all when position is nil, other than moving. It is nil when there is
+// none. Filters apply after a query page is read, so pages can come back
+// empty before the end of the list.
+func (h *handler) nextByPosition(ctx context.Context, moving string, position *float64) (*Todo, error) {
+	opts := ListOptions{
+		Limit:         2,
+		Fields:        []string{"id", "position"},
+		ByPosition:    true,
+		AfterPosition: position,
+	}
+
+	for {
+		page, cursor, err := h.store.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, todo := range page {
+			if todo.Id != moving {
+				return &todo, nil
+			}
+		}
+
+		if cursor == "" {
+			return nil, nil
+		}
+		opts.Cursor = cursor
+	}
+}
+
+// processPosition moves a todo for drag and drop ordering. Only the moved
+// todo is written: it gets a position halfway between its new neighbours, so
+// the others keep theirs.
+func (h *handler) processPosition(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return bodyError(ctx, err)
+	}
+
+	var moveTodo MoveTodo
+	err = decodeBody(body, &moveTodo)
+	if err != nil {
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
+	}
+
+	err = validate.Struct(&moveTodo)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return validationError(ctx, fieldErrors(err, nil))
+	}
+
+	after := ""
+	if moveTodo.After != nil {
+		after = *moveTodo.After
+	}
+	logger.InfoContext(ctx, "Received position request", slog.String("id", id), slog.String("after", after))
+
+	if after == id {
+		return clientErrorMessage(http.StatusBadRequest, "a todo can't be moved after itself")
+	}
+
+	position, err := h.positionAfter(ctx, id, after)
+	if errors.Is(err, ErrNotFound) {
+		logger.InfoContext(ctx, "Unknown todo to move after", slog.String("after", after), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, "after must be the id of another todo")
+	}
+	if errors.Is(err, errPositionExhausted) {
+		logger.WarnContext(ctx, "Positions exhausted", slog.String("id", id), slog.String("after", after))
+		return clientErrorMessage(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	res, err := h.store.SetPosition(ctx, id, position)
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	logger.InfoContext(ctx, "Moved todo", slog.String("id", id), slog.Float64("position", position))
+	h.recordHistory(ctx, actionMove, *res)
+
+	return todoResponse(ctx, http.StatusOK, res)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// positionedTodos inserts n todos into store, positioned in reverse of the id
+// order the store lists in, and returns their ids in position order.
+func positionedTodos(t *testing.T, store *memoryStore, n int) []string {
+	t.Helper()
+
+	store.newID = sequentialIDs()
+	var ids []string
+	for i := range n {
+		todo, err := store.Insert(context.Background(), CreateTodo{Task: "write tests"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.SetPosition(context.Background(), todo.Id, float64(10-i)); err != nil {
+			t.Fatal(err)
+		}
+		ids = append([]string{todo.Id}, ids...)
+	}
+
+	return ids
+}
+
+// listPages follows the pages of GET /api/task with query to the end and
+// returns the ids listed.
+func listPages(t *testing.T, h *handler, query map[string]string) []string {
+	t.Helper()
+
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages == 10 {
+			t.Fatalf("more than 10 pages, got %v", ids)
+		}
+
+		res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: query})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+		}
+		var page TodoPage
+		var items []Todo
+		page.Items = &items
+		decodeData(t, res, &page)
+		for _, todo := range items {
+			ids = append(ids, todo.Id)
+		}
+
+		if page.NextCursor == "" {
+			return ids
+		}
+		query["cursor"] = page.NextCursor
+	}
+}
+
+func TestListIsSortedByPositionAcrossPages(t *testing.T) {
+	store := newMemoryStore()
+	want := positionedTodos(t, store, 5)
+	h := newHandler(store)
+
+	got := listPages(t, h, map[string]string{"limit": "2", "sort": "position"})
+	if !slices.Equal(got, want) {
+		t.Errorf("sort=position pages list %v, want %v", got, want)
+	}
+
+	// Ordering by position is opt-in.
+	got = listPages(t, h, map[string]string{"limit": "2"})
+	slices.Reverse(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("pages without sort list %v, want the store's order %v", got, want)
+	}
+}
+
+func TestPositionCursorSkipsMovedTodos(t *testing.T) {
+	store := newMemoryStore()
+	ids := positionedTodos(t, store, 3)
+	h := newHandler(store)
+
+	query := map[string]string{"limit": "1", "sort": "position"}
+	res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: query})
+	var page TodoPage
+	var items []Todo
+	page.Items = &items
+	decodeData(t, res, &page)
+	if len(items) != 1 || items[0].Id != ids[0] {
+		t.Fatalf("first page = %v, want %s", items, ids[0])
+	}
+
+	// Moving the first todo to the bottom doesn't repeat it on the next page,
+	// which starts after where it was.
+	if _, err := store.SetPosition(context.Background(), ids[0], 1e15); err != nil {
+		t.Fatal(err)
+	}
+	query["cursor"] = page.NextCursor
+	res = serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: query})
+	items = nil
+	decodeData(t, res, &page)
+	if len(items) != 1 || items[0].Id != ids[1] {
+		t.Errorf("second page = %v, want %s", items, ids[1])
+	}
+}
+
+func TestInvalidPositionCursorIsBadRequest(t *testing.T) {
+	h := newHandler(newMemoryStore())
+
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: map[string]string{"cursor": cursor, "sort": "position"}})
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("cursor %q: status = %d, want %d", cursor, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestMoveTodo(t *testing.T) {
+	tests := []struct {
+		name string
+		// move is the index of the todo moved, after the index of the todo
+		// it is moved after, or -1 for the top.
+		move, after int
+		want        []int
+	}{
+		{name: "top", move: 2, after: -1, want: []int{2, 0, 1, 3}},
+		{name: "between", move: 0, after: 1, want: []int{1, 0, 2, 3}},
+		{name: "bottom", move: 1, after: 3, want: []int{0, 2, 3, 1}},
+		{name: "in place", move: 1, after: 0, want: []int{0, 1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			ids := positionedTodos(t, store, 4)
+			h := newHandler(store)
+
+			body := `{}`
+			if tt.after >= 0 {
+				body = `{"after":"` + ids[tt.after] + `"}`
+			}
+			res := serve(t, h, request{HTTPMethod: "PUT", Path: "/api/task/" + ids[tt.move] + "/position", Body: body})
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+			}
+
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, ids[i])
+			}
+			if got := listPages(t, h, map[string]string{"sort": "position"}); !slices.Equal(got, want) {
+				t.Errorf("list = %v, want %v", got, want)
+			}
+		})
+	}
+
+	store := newMemoryStore()
+	ids := positionedTodos(t, store, 2)
+	if _, err := store.SoftDelete(context.Background(), ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	res := serve(t, newHandler(store), request{HTTPMethod: "PUT", Path: "/api/task/" + ids[0] + "/position", Body: `{"after":"` + ids[1] + `"}`})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("after a deleted todo: status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestPositionListQueriesIndex(t *testing.T) {
+	lastKey := map[string]any{
+		"id":       map[string]any{"S": "20000000-0000-4000-8000-000000000000"},
+		"list":     map[string]any{"S": "shared"},
+		"position": map[string]any{"N": "2.5"},
+	}
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		if _, ok := input["ExclusiveStartKey"]; ok {
+			return map[string]any{"Items": []any{}}
+		}
+
+		return map[string]any{
+			"Items": []any{map[string]any{
+				"id":       lastKey["id"],
+				"task":     map[string]any{"S": "write tests"},
+				"position": lastKey["position"],
+			}},
+			"LastEvaluatedKey": lastKey,
+		}
+	})
+
+	query := map[string]string{"limit": "1", "sort": "position"}
+	listPages(t, newHandler(store), query)
+
+	if scans := fake.callsTo("Scan"); len(scans) > 0 {
+		t.Errorf("sort=position scanned the table %d times", len(scans))
+	}
+	queries := fake.callsTo("Query")
+	if len(queries) != 2 {
+		t.Fatalf("Query called %d times, want 2", len(queries))
+	}
+	for _, q := range queries {
+		if q.Input["IndexName"] != positionIndexName {
+			t.Errorf("queried index %v, want %s", q.Input["IndexName"], positionIndexName)
+		}
+	}
+	start, _ := queries[1].Input["ExclusiveStartKey"].(map[string]any)
+	for name, value := range lastKey {
+		got, _ := start[name].(map[string]any)
+		want := value.(map[string]any)
+		if len(start) != len(lastKey) || got["S"] != want["S"] || got["N"] != want["N"] {
+			t.Errorf("second page starts at %v, want the LastEvaluatedKey %v", start, lastKey)
+			break
+		}
+	}
+}
+
+func TestMoveQueriesNeighbour(t *testing.T) {
+	const (
+		moving = "10000000-0000-4000-8000-000000000000"
+		after  = "20000000-0000-4000-8000-000000000000"
+		next   = "30000000-0000-4000-8000-000000000000"
+	)
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		switch op {
+		case "GetItem":
+			return map[string]any{"Item": map[string]any{
+				"id":       map[string]any{"S": after},
+				"position": map[string]any{"N": "10"},
+			}}
+		case "Query":
+			return map[string]any{"Items": []any{map[string]any{
+				"id":       map[string]any{"S": next},
+				"position": map[string]any{"N": "20"},
+			}}}
+		case "UpdateItem":
+			return map[string]any{"Attributes": map[string]any{
+				"id":       map[string]any{"S": moving},
+				"task":     map[string]any{"S": "write tests"},
+				"position": map[string]any{"N": "15"},
+				"version":  map[string]any{"N": "2"},
+			}}
+		default:
+			return map[string]any{}
+		}
+	})
+
+	res := serve(t, newHandler(store), request{HTTPMethod: "PUT", Path: "/api/task/" + moving + "/position", Body: `{"after":"` + after + `"}`})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+
+	if scans := fake.callsTo("Scan"); len(scans) > 0 {
+		t.Errorf("moving a todo scanned the table %d times", len(scans))
+	}
+	queries := fake.callsTo("Query")
+	if len(queries) != 1 || queries[0].Input["IndexName"] != positionIndexName {
+		t.Fatalf("queries = %v, want one of %s", queries, positionIndexName)
+	}
+	if !hasValue(queries[0].Input, "N", "10") {
+		t.Errorf("neighbour query %v doesn't start after position 10", queries[0].Input)
+	}
+	updates := fake.callsTo("UpdateItem")
+	if len(updates) != 1 || !hasValue(updates[0].Input, "N", "15") {
+		t.Errorf("updates = %v, want position 15", updates)
+	}
+}
+
+// hasValue reports whether the ExpressionAttributeValues of input hold a
+// value of type typ, like "N", equal to want.
+func hasValue(input map[string]any, typ, want string) bool {
+	values, _ := input["ExpressionAttributeValues"].(map[string]any)
+	for _, value := range values {
+		if v, _ := value.(map[string]any); v[typ] == want {
+			return true
+		}
+	}
+
+	return false
+}
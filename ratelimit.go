@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// rateLimit is how many requests per second a single caller may make, with
+// bursts of up to rateLimitBurst. Zero, the default, disables rate limiting.
+//
+// The token buckets are kept per container, like the circuit breaker, so the
+// effective limit of a function grows with its concurrency: N warm containers
+// each let a caller through at rateLimit. That is enough to fend off a single
+// runaway client, but isn't a quota; put a usage plan on the API Gateway
+// stage for one.
+var (
+	rateLimit      = envFloat("RATE_LIMIT_PER_SECOND", 0)
+	rateLimitBurst = envInt("RATE_LIMIT_BURST", 20)
+)
+
+// rateLimitMaxCallers bounds how many callers a container tracks. Past it,
+// the buckets of callers that have been idle long enough to be full again are
+// dropped, since a new bucket behaves the same.
+const rateLimitMaxCallers = 10000
+
+// tokenBucket holds up to rateLimitBurst tokens, refilled at rateLimit per
+// second. Every request takes one.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+var callerLimiter = newRateLimiter(rateLimit, rateLimitBurst)
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of caller. When there is none, it
+// returns false and how long until there will be.
+func (l *rateLimiter) allow(caller string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[caller]
+	if !ok {
+		if len(l.buckets) >= rateLimitMaxCallers {
+			l.sweep(now)
+		}
+
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[caller] = bucket
+	}
+
+	l.refill(bucket, now)
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.updated = now
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for caller, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= l.burst {
+			delete(l.buckets, caller)
+		}
+	}
+}
+
+// rateLimitApplies reports whether req counts against its caller's limit.
+// Like chaos, health checks, version info and metrics are spared.
+func rateLimitApplies(req request) bool {
+	if rateLimit <= 0 {
+		return false
+	}
+
+	switch {
+	case req.HTTPMethod == "OPTIONS",
+		req.Path == "/api/health",
+		req.Path == "/api/ready",
+		req.Path == "/api/version",
+		req.Path == "/metrics":
+		return false
+	default:
+		return true
+	}
+}
+
+// callerKey identifies who made req: the subject of their token when auth is
+// on, and their source IP otherwise.
+func callerKey(ctx context.Context, req request) string {
+	if sub := subjectFrom(ctx); sub != "" {
+		return "sub:" + sub
+	}
+
+	if req.SourceIP != "" {
+		return "ip:" + req.SourceIP
+	}
+
+	return ""
+}
+
+// limitRate answers req with a 429 when its caller is over rateLimit, and
+// returns false when req may go ahead.
+func limitRate(ctx context.Context, req request) (events.APIGatewayProxyResponse, bool) {
+	caller := callerKey(ctx, req)
+	if caller == "" {
+		return events.APIGatewayProxyResponse{}, false
+	}
+
+	ok, retryAfter := callerLimiter.allow(caller, time.Now())
+	if ok {
+		return events.APIGatewayProxyResponse{}, false
+	}
+
+	logger.WarnContext(ctx, "Rate limited caller", slog.String("caller", caller), slog.Duration("retry_after", retryAfter))
+	res, _ := clientErrorMessage(http.StatusTooManyRequests, "rate limit exceeded")
+
+	return withRetryAfter(res, retryAfter), true
+}
+
+// forwardedFor returns the client address of an X-Forwarded-For header, which
+// is its first entry.
+func forwardedFor(header string) string {
+	client, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(client)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// redactTask keeps task text, which may hold personal data, out of the logs.
+// Tasks are logged as a length marker instead, while ids, statuses and
+// versions are still logged. It is off by default so the logs show what the
+// sample does.
+var redactTask = os.Getenv("LOG_REDACT_TASK") == "true"
+
+// logText returns text as it may be logged.
+func logText(text string) string {
+	if !redactTask {
+		return text
+	}
+
+	return fmt.Sprintf("[REDACTED %d chars]", len([]rune(text)))
+}
+
+// The log types have the fields of the originals but no LogValue method, so
+// that the methods below don't call themselves.
+type (
+	logTodo        Todo
+	logCreateTodo  CreateTodo
+	logReplaceTodo ReplaceTodo
+	logPatchTodo   PatchTodo
+)
+
+func (t Todo) LogValue() slog.Value {
+	t.Task = logText(t.Task)
+	return slog.AnyValue(logTodo(t))
+}
+
+func (t CreateTodo) LogValue() slog.Value {
+	t.Task = logText(t.Task)
+	return slog.AnyValue(logCreateTodo(t))
+}
+
+func (t ReplaceTodo) LogValue() slog.Value {
+	t.Task = logText(t.Task)
+	return slog.AnyValue(logReplaceTodo(t))
+}
+
+func (t PatchTodo) LogValue() slog.Value {
+	if t.Task != nil {
+		task := logText(*t.Task)
+		t.Task = &task
+	}
+
+	return slog.AnyValue(logPatchTodo(t))
+}
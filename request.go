@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// request is the part of an HTTP event the handlers work with. It is filled
+// from either an API Gateway proxy event or an ALB target group event.
+type request struct {
+	HTTPMethod            string
+	Path                  string
+	Resource              string
+	Headers               map[string]string
+	QueryStringParameters map[string]string
+	PathParameters        map[string]string
+	Body                  string
+	IsBase64Encoded       bool
+	RequestID             string
+	Stage                 string
+	// SourceIP is the address of the client, which rate limiting keys on
+	// when there is no authenticated subject.
+	SourceIP string
+}
+
+// redactedHeaders carry credentials and are masked when a request is logged.
+var redactedHeaders = []string{"Authorization", "Cookie"}
+
+// LogValue logs req with the values of redactedHeaders masked, and its body,
+// which holds tasks, too when redactTask is set.
+func (req request) LogValue() slog.Value {
+	headers := make(map[string]string, len(req.Headers))
+	for key, value := range req.Headers {
+		for _, name := range redactedHeaders {
+			if strings.EqualFold(key, name) {
+				value = "[REDACTED]"
+			}
+		}
+		headers[key] = value
+	}
+
+	return slog.GroupValue(
+		slog.String("http_method", req.HTTPMethod),
+		slog.String("path", req.Path),
+		slog.String("resource", req.Resource),
+		slog.Any("headers", headers),
+		slog.Any("query", req.QueryStringParameters),
+		slog.Any("path_parameters", req.PathParameters),
+		slog.String("body", logText(req.Body)),
+		slog.Bool("base64_encoded", req.IsBase64Encoded),
+	)
+}
+
+func fromAPIGateway(event events.APIGatewayProxyRequest) request {
+	return request{
+		HTTPMethod:            event.HTTPMethod,
+		Path:                  event.Path,
+		Resource:              event.Resource,
+		Headers:               event.Headers,
+		QueryStringParameters: event.QueryStringParameters,
+		PathParameters:        event.PathParameters,
+		Body:                  event.Body,
+		IsBase64Encoded:       event.IsBase64Encoded,
+		RequestID:             event.RequestContext.RequestID,
+		Stage:                 event.RequestContext.Stage,
+		SourceIP:              event.RequestContext.Identity.SourceIP,
+	}
+}
+
+// fromALB maps an ALB event onto request. Unlike API Gateway, the load
+// balancer doesn't match resources, decode the query string or provide a
+// request id or source IP, so those are filled in here.
+func fromALB(ctx context.Context, event events.ALBTargetGroupRequest) request {
+	headers := event.Headers
+	if headers == nil && event.MultiValueHeaders != nil {
+		headers = make(map[string]string, len(event.MultiValueHeaders))
+		for key, values := range event.MultiValueHeaders {
+			headers[key] = strings.Join(values, ", ")
+		}
+	}
+
+	query := make(map[string]string)
+	for key, value := range event.QueryStringParameters {
+		query[albUnescape(key)] = albUnescape(value)
+	}
+	for key, values := range event.MultiValueQueryStringParameters {
+		if len(values) > 0 {
+			query[albUnescape(key)] = albUnescape(values[len(values)-1])
+		}
+	}
+
+	resource, params := matchRoute(event.Path)
+
+	var requestID string
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+
+	req := request{
+		HTTPMethod:            event.HTTPMethod,
+		Path:                  event.Path,
+		Resource:              resource,
+		Headers:               headers,
+		QueryStringParameters: query,
+		PathParameters:        params,
+		Body:                  event.Body,
+		IsBase64Encoded:       event.IsBase64Encoded,
+		RequestID:             requestID,
+	}
+	req.SourceIP = forwardedFor(requestHeader(req, "X-Forwarded-For"))
+
+	return req
+}
+
+// albUnescape decodes a query string key or value, which ALB passes through
+// exactly as the client sent it.
+func albUnescape(s string) string {
+	unescaped, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+
+	return unescaped
+}
+
+// routeTemplates are the resources dispatch serves, as declared in
+// template.yml. Static paths come first so that e.g. /api/task/count isn't
+// taken for a todo id.
+var routeTemplates = []string{
+	"/metrics",
+	"/api/health",
+	"/api/ready",
+	"/api/version",
+	"/api/openapi.json",
+	"/api/task",
+	"/api/task/count",
+	"/api/task/export",
+	"/api/task/search",
+	"/api/task/status",
+	"/api/task/completed",
+	"/api/task/active",
+	"/api/task/{id}/status",
+	"/api/task/{id}/position",
+	"/api/task/{id}/restore",
+	"/api/task/{id}/toggle",
+	"/api/task/{id}/complete",
+	"/api/task/{id}/incomplete",
+	"/api/task/{id}/history",
+	"/api/task/{id}",
+	"/api/undoTask/{id}",
+	"/api/deleteTask/{id}",
+}
+
+// matchRoute finds the resource template path belongs to and extracts its
+// path parameters, the way API Gateway does before invoking the function.
+func matchRoute(path string) (string, map[string]string) {
+	for _, template := range routeTemplates {
+		if params, ok := matchTemplate(template, path); ok {
+			return template, params
+		}
+	}
+
+	return "", nil
+}
+
+// matchTemplate reports whether path is of the resource template and extracts
+// its path parameters.
+func matchTemplate(template string, path string) (map[string]string, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	parts := strings.Split(strings.Trim(template, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, "{") && segments[i] != "":
+			params[strings.Trim(part, "{}")] = segments[i]
+		case part != segments[i]:
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// pathID returns the id path parameter of req, which dispatch routed to the
+// resource template. Non-proxy integrations and some ALB setups don't pass
+// path parameters, so without one the id is taken from req.Path, but only if
+// the path is of template: /api/task/{id}/toggle holds no id for
+// /api/task/{id}.
+func pathID(req request, template string) (string, bool) {
+	if id, ok := req.PathParameters["id"]; ok {
+		return id, true
+	}
+
+	params, _ := matchTemplate(template, req.Path)
+	id, ok := params["id"]
+
+	return id, ok
+}
+
+func toALBResponse(res events.APIGatewayProxyResponse, multiValue bool) events.ALBTargetGroupResponse {
+	response := events.ALBTargetGroupResponse{
+		StatusCode:        res.StatusCode,
+		StatusDescription: fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+		Body:              res.Body,
+		IsBase64Encoded:   res.IsBase64Encoded,
+	}
+
+	// A target group with multi-value headers enabled ignores Headers.
+	if multiValue {
+		response.MultiValueHeaders = make(map[string][]string, len(res.Headers))
+		for key, value := range res.Headers {
+			response.MultiValueHeaders[key] = []string{value}
+		}
+	} else {
+		response.Headers = res.Headers
+	}
+
+	return response
+}
+
+// eventSource holds just enough of an incoming event to tell which service
+// sent it. Only ALB events carry requestContext.elb, and only warmers set
+// source to warmerSource.
+type eventSource struct {
+	Source         string `json:"source"`
+	RequestContext struct {
+		ELB *json.RawMessage `json:"elb"`
+	} `json:"requestContext"`
+}
+
+// warmerSource is the source of the synthetic events scheduled warmers send
+// to keep containers warm. Warmers that can only send HTTP requests use
+// warmupPath instead.
+const (
+	warmerSource = "warmer"
+	warmupPath   = "/warmup"
+)
+
+// warmupResponse answers a warmer without routing, so that its pings neither
+// touch DynamoDB nor show up in the request logs and metrics.
+func warmupResponse(ctx context.Context) events.APIGatewayProxyResponse {
+	logger.InfoContext(ctx, "Warmed up", slog.Bool("warmup", true), slog.Bool("cold_start", coldStart))
+	coldStart = false
+
+	// A map of a bool always marshals.
+	body, _ := json.Marshal(DataBody{Data: map[string]bool{"warmup": true}, APIVersion: apiVersion})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(body),
+	}
+}
+
+// handle is the Lambda entrypoint. It accepts API Gateway proxy and ALB
+// target group events and answers in the matching response format.
+func (h *handler) handle(ctx context.Context, payload json.RawMessage) (any, error) {
+	var source eventSource
+	err := json.Unmarshal(payload, &source)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.Source == warmerSource {
+		return warmupResponse(ctx), nil
+	}
+
+	if source.RequestContext.ELB != nil {
+		var event events.ALBTargetGroupRequest
+		err = json.Unmarshal(payload, &event)
+		if err != nil {
+			return nil, err
+		}
+
+		if event.Path == warmupPath {
+			return toALBResponse(warmupResponse(ctx), event.MultiValueHeaders != nil), nil
+		}
+
+		res, err := h.router(ctx, fromALB(ctx, event))
+		return toALBResponse(res, event.MultiValueHeaders != nil), err
+	}
+
+	var event events.APIGatewayProxyRequest
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	if event.Path == warmupPath {
+		return warmupResponse(ctx), nil
+	}
+
+	return h.router(ctx, fromAPIGateway(event))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRequestIsDumpedRedactedAtDebug(t *testing.T) {
+	req := request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers:    map[string]string{"authorization": "Bearer secret-token", "Cookie": "session=secret", "Accept": "text/csv"},
+	}
+
+	logs := captureLogs(t, slog.LevelDebug)
+	serve(t, newHandler(newMemoryStore()), req)
+	if !strings.Contains(logs.String(), `"msg":"Received request"`) {
+		t.Fatalf("no request dump at debug level:\n%s", logs)
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("credentials logged:\n%s", logs)
+	}
+	if !strings.Contains(logs.String(), `"Accept":"text/csv"`) {
+		t.Errorf("other headers not logged:\n%s", logs)
+	}
+
+	logs = captureLogs(t, slog.LevelInfo)
+	serve(t, newHandler(newMemoryStore()), req)
+	if strings.Contains(logs.String(), "Received request") {
+		t.Errorf("request dumped at info level:\n%s", logs)
+	}
+}
+
+func TestPathID(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+
+	tests := []struct {
+		name     string
+		req      request
+		template string
+		wantID   string
+		wantOK   bool
+	}{
+		{
+			name:     "proxy",
+			req:      request{Path: "/Prod/api/task/" + id, PathParameters: map[string]string{"id": id}},
+			template: "/api/task/{id}",
+			wantID:   id,
+			wantOK:   true,
+		},
+		{name: "non-proxy", req: request{Path: "/api/task/" + id}, template: "/api/task/{id}", wantID: id, wantOK: true},
+		{name: "non-proxy sub-resource", req: request{Path: "/api/task/" + id + "/status"}, template: "/api/task/{id}/status", wantID: id, wantOK: true},
+		{name: "non-proxy legacy", req: request{Path: "/api/undoTask/" + id}, template: "/api/undoTask/{id}", wantID: id, wantOK: true},
+		{name: "other resource", req: request{Path: "/api/task/" + id + "/toggle"}, template: "/api/task/{id}"},
+		{name: "other prefix", req: request{Path: "/api/deleteTask/" + id}, template: "/api/task/{id}"},
+		{name: "no id", req: request{Path: "/api/task/"}, template: "/api/task/{id}"},
+		{name: "empty parameter", req: request{Path: "/api/task/", PathParameters: map[string]string{"id": ""}}, template: "/api/task/{id}", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, ok := pathID(tt.req, tt.template)
+			if id != tt.wantID || ok != tt.wantOK {
+				t.Errorf("pathID = %q, %v, want %q, %v", id, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
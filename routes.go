@@ -1,249 +1,2298 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type UpdateTodo struct {
-	Status bool `json:"status"`
+	Status  bool `json:"status"`
+	Version *int `json:"version,omitempty"`
 }
 
+// UnmarshalJSON accepts any status flexBool does, for clients whose
+// serializers send booleans as numbers or strings. Like decodeBody, it
+// rejects unknown fields.
+func (u *UpdateTodo) UnmarshalJSON(data []byte) error {
+	var body struct {
+		Status  flexBool `json:"status"`
+		Version *int     `json:"version,omitempty"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		// The decoder doesn't name the field of errors from UnmarshalJSON.
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field == "" {
+			typeErr.Field = "status"
+		}
+
+		return err
+	}
+
+	u.Status = bool(body.Status)
+	u.Version = body.Version
+
+	return nil
+}
+
+// flexBool is a bool that also decodes from "true" and "false" and from the
+// numbers 0 and 1.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`, "1":
+		*b = true
+	case "false", `"false"`, "0":
+		*b = false
+	case "null":
+		// Like a bool, null leaves the value alone.
+	default:
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*b)}
+	}
+
+	return nil
+}
+
+// PatchTodo is the JSON Merge Patch (RFC 7396) PATCH /api/task/{id} accepts.
+// Fields left out of it are left alone. Neither can be removed, so nulls are
+// rejected before decoding.
+type PatchTodo struct {
+	Task    *string `json:"task,omitempty" validate:"omitempty,max=500"`
+	Status  *bool   `json:"status,omitempty"`
+	Version *int    `json:"version,omitempty"`
+}
+
+// BatchStatusUpdate is the body of PUT /api/task/status. The id limit matches
+// DynamoDB's transaction limit, which keeps a single request short.
+type BatchStatusUpdate struct {
+	Ids    []string `json:"ids" validate:"required,min=1,max=25,dive,uuid"`
+	Status *bool    `json:"status" validate:"required"`
+}
+
+type BatchStatusResult struct {
+	Updated  []Todo   `json:"updated"`
+	NotFound []string `json:"notFound"`
+	Failed   []string `json:"failed"`
+}
+
+// ReplaceTodo is the full representation PUT /api/task/{id} accepts in place
+// of the legacy empty body.
+type ReplaceTodo struct {
+	Task    string `json:"task" validate:"required,max=500"`
+	Status  bool   `json:"status"`
+	Version *int   `json:"version,omitempty"`
+}
+
+// CreateTodo is the body of POST /api/task. Id is only accepted when
+// allowClientIDs is set. Todos start out active unless Status is true.
 type CreateTodo struct {
-	Task string `json:"task" validate:"required"`
+	Id        string `json:"id,omitempty" validate:"omitempty,uuid"`
+	Task      string `json:"task" validate:"required,max=500"`
+	Status    bool   `json:"status,omitempty"`
+	ExpiresAt *int64 `json:"expiresAt,omitempty" validate:"omitempty,future"`
+}
+
+type HealthStatus struct {
+	Status string `json:"status"`
+	Time   string `json:"time"`
+}
+
+// apiVersion is sent along with every JSON body, so that clients can tell a
+// future incompatible shape from this one.
+const apiVersion = "1"
+
+// DataBody is the envelope of every successful JSON response.
+type DataBody struct {
+	Data       any    `json:"data"`
+	APIVersion string `json:"apiVersion"`
+}
+
+type ErrorDetail struct {
+	Code      int          `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+}
+
+// ErrorBody is the envelope of every error response.
+type ErrorBody struct {
+	Error      ErrorDetail `json:"error"`
+	APIVersion string      `json:"apiVersion"`
+}
+
+type FieldError struct {
+	Index   *int   `json:"index,omitempty"`
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// DryRunTodo is the todo a mutation would have produced under ?dryRun=true.
+type DryRunTodo struct {
+	*Todo
+	DryRun bool `json:"dryRun"`
+}
+
+type DryRunBatch struct {
+	Items  []Todo `json:"items"`
+	DryRun bool   `json:"dryRun"`
+}
+
+type DeleteAllResult struct {
+	Deleted int `json:"deleted"`
+}
+
+type TodoCount struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+}
+
+// SearchResult is a page of search matches. Truncated is set along with
+// NextCursor, for clients from before searches paged.
+type SearchResult struct {
+	Items      []Todo `json:"items"`
+	NextCursor string `json:"nextCursor"`
+	Truncated  bool   `json:"truncated"`
+}
+
+// TodoPage is a page of todos. Items holds []Todo, or only the requested
+// fields of each todo when ?fields is set.
+type TodoPage struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"nextCursor"`
+}
+
+var validate *validator.Validate = newValidator()
+
+// newValidator reports fields by their JSON names, which is what clients see.
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	// future checks an epoch seconds timestamp.
+	v.RegisterValidation("future", func(fl validator.FieldLevel) bool {
+		return fl.Field().Int() > time.Now().Unix()
+	})
+
+	return v
+}
+
+// defaultPageSize is the page size of GET /api/task without ?limit, or with
+// limit=0. Larger limits are clamped to maxPageSize.
+const defaultPageSize = 20
+
+var maxPageSize = envInt("MAX_PAGE_SIZE", 100)
+
+// maxBodyBytes caps the size of a decoded request body.
+var maxBodyBytes = envInt("MAX_BODY_BYTES", 64*1024)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+var errEmptyBody = errors.New("request body is required")
+
+var errUnsupportedMediaType = errors.New("request body must be application/json")
+
+// errUnknownField is returned by decodeBody for a field the target type
+// doesn't declare.
+var errUnknownField = errors.New("unknown field")
+
+var errMissingVersion = errors.New("If-Match header or version field is required")
+
+// anyVersion stands in for the expected version of an update that applies
+// whatever the stored version is. Versions start at 1.
+const anyVersion = -1
+
+// allowBulkDelete enables DELETE /api/task, which wipes the whole table.
+var allowBulkDelete = os.Getenv("ALLOW_BULK_DELETE") == "true"
+
+// handler serves the API on top of a TodoStore.
+type handler struct {
+	store TodoStore
+}
+
+func newHandler(store TodoStore) *handler {
+	return &handler{store: store}
+}
+
+func (h *handler) router(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	start := time.Now()
+	correlationID := requestHeader(req, "X-Request-ID")
+	if correlationID == "" {
+		correlationID = uuid.NewString()
+	}
+
+	ctx = withRequestID(ctx, req.RequestID)
+	ctx = withCorrelationID(ctx, correlationID)
+	ctx = withBaseURL(ctx, baseURL(req))
+	ctx = withLogAttrs(ctx,
+		slog.String("request_id", req.RequestID),
+		slog.String("http_method", req.HTTPMethod),
+		slog.String("path", req.Path),
+	)
+	// The access line at the end is what to build on; the whole request,
+	// credentials masked, is only for debugging.
+	logger.DebugContext(ctx, "Received request", slog.Any("request", req))
+
+	ctx, seg := xray.BeginSubsegment(ctx, segmentName(req))
+	seg.AddAnnotation("request_id", req.RequestID)
+	seg.AddAnnotation("correlation_id", correlationID)
+	if id := traceID(ctx); id != "" {
+		ctx = withLogAttrs(ctx, slog.String("trace_id", id))
+	}
+
+	// Join the trace of an upstream caller that sent a traceparent header.
+	ctx = otel.GetTextMapPropagator().Extract(ctx, requestCarrier(req))
+	ctx, span := tracer.Start(ctx, routeName(req),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.HTTPMethod),
+			attribute.String("url.path", req.Path),
+			attribute.String("aws.request_id", req.RequestID),
+		),
+	)
+
+	var usage *capacityUsage
+	if capacityDebug {
+		ctx, usage = withCapacityUsage(ctx)
+	}
+
+	var served *servedBy
+	if secondaryRegion != "" {
+		ctx, served = withServedBy(ctx)
+	}
+
+	res, err := h.safeDispatch(ctx, req)
+	cors := corsHeaders(requestHeader(req, "Origin"))
+	if vary, ok := cors["Vary"]; ok {
+		// Keep the Vary of the response, e.g. Accept for negotiated lists.
+		cors["Vary"] = addVary(res.Headers["Vary"], vary)
+	}
+	res.Headers = mergeHeaders(res.Headers, cors)
+	res.Headers["X-Request-ID"] = correlationID
+	res = compressResponse(res, requestHeader(req, "Accept-Encoding"))
+
+	seg.AddAnnotation("status", res.StatusCode)
+	if usage != nil {
+		read, write := usage.totals()
+		seg.AddAnnotation("consumed_rcu", read)
+		seg.AddAnnotation("consumed_wcu", write)
+		span.SetAttributes(
+			attribute.Float64("aws.dynamodb.consumed_read_capacity", read),
+			attribute.Float64("aws.dynamodb.consumed_write_capacity", write),
+		)
+		ctx = withLogAttrs(ctx, slog.Float64("consumed_rcu", read), slog.Float64("consumed_wcu", write))
+	}
+	if served != nil && served.get() != "" {
+		region := served.get()
+		res.Headers["X-Served-By-Region"] = region
+		seg.AddAnnotation("served_region", region)
+		span.SetAttributes(attribute.String("cloud.region", region))
+		ctx = withLogAttrs(ctx, slog.String("served_region", region))
+	}
+	seg.Close(err)
+
+	latency := time.Since(start)
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	span.End()
+	requestDuration.Record(ctx, latency.Seconds(), metric.WithAttributes(
+		attribute.String("http.request.method", req.HTTPMethod),
+		attribute.String("http.route", routeName(req)),
+		attribute.Int("http.response.status_code", res.StatusCode),
+	))
+	flushTelemetry(ctx)
+
+	logger.InfoContext(ctx, "Completed request",
+		slog.Any("query", accessLogQuery(req.QueryStringParameters)),
+		slog.Int("status", res.StatusCode),
+		slog.Int("bytes", responseBytes(res)),
+		slog.Int64("latency_ms", latency.Milliseconds()),
+		slog.Bool("cold_start", coldStart),
+	)
+	emitMetrics(ctx, requestMetrics(routeName(req), res.StatusCode, latency, coldStart))
+	localMetrics.record(res.StatusCode, latency)
+	coldStart = false
+
+	return res, err
+}
+
+// accessLogParams are the query parameters logged with every request. Others
+// are left out since they may carry user content, like the search query.
+var accessLogParams = []string{"limit", "cursor", "status"}
+
+// accessLogQuery picks the accessLogParams out of query.
+func accessLogQuery(query map[string]string) map[string]string {
+	logged := make(map[string]string)
+	for _, name := range accessLogParams {
+		if value, ok := query[name]; ok {
+			logged[name] = value
+		}
+	}
+
+	return logged
+}
+
+// responseBytes is the size of the body sent to the client.
+func responseBytes(res events.APIGatewayProxyResponse) int {
+	if !res.IsBase64Encoded {
+		return len(res.Body)
+	}
+
+	return base64.StdEncoding.DecodedLen(len(res.Body)) - strings.Count(res.Body, "=")
+}
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type baseURLKey struct{}
+
+// withBaseURL stores the URL the API was called under, which Location
+// headers are built from.
+func withBaseURL(ctx context.Context, url string) context.Context {
+	return context.WithValue(ctx, baseURLKey{}, url)
+}
+
+func baseURLFrom(ctx context.Context) string {
+	url, _ := ctx.Value(baseURLKey{}).(string)
+	return url
+}
+
+// baseURL is the scheme, host and stage req was sent to, e.g.
+// "https://abc123.execute-api.ap-northeast-1.amazonaws.com/Prod", or "" when
+// the Host header is missing. Custom domains map the stage away, so the stage
+// is only added for the default execute-api host.
+func baseURL(req request) string {
+	host := requestHeader(req, "Host")
+	if host == "" {
+		return ""
+	}
+
+	scheme := requestHeader(req, "X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "https"
+	}
+
+	url := scheme + "://" + host
+	if req.Stage != "" && strings.HasSuffix(host, ".amazonaws.com") {
+		url += "/" + req.Stage
+	}
+
+	return url
+}
+
+type correlationIDKey struct{}
+
+// withCorrelationID stores the id tying together every log line of a request,
+// which the logger picks up from the context.
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+func correlationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// validateID checks that id is a UUID in its canonical 36 character form, as
+// generated by insertItem, so malformed ids never reach DynamoDB.
+func validateID(id string) error {
+	if len(id) != 36 {
+		return fmt.Errorf("id must be 36 characters, got %d", len(id))
+	}
+
+	_, err := uuid.Parse(id)
+	return err
+}
+
+// idHandler serves a route for a single todo, given the id from its path.
+type idHandler func(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error)
+
+// withID calls next with the validated id path parameter of req, which
+// dispatch routed to the resource template. A missing or malformed id is
+// answered with a 400 before next runs, so routes with an {id} go through here
+// rather than reading the id themselves.
+func withID(ctx context.Context, req request, template string, next idHandler) (events.APIGatewayProxyResponse, error) {
+	id, ok := pathID(req, template)
+	if !ok {
+		logger.InfoContext(ctx, "Missing id", slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	err := validateID(id)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid id", slog.String("id", id), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	return next(ctx, req, id)
+}
+
+// requestHeader looks up a request header case-insensitively, since clients
+// and API Gateway don't agree on header casing.
+func requestHeader(req request, name string) string {
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func mergeHeaders(headers map[string]string, extra map[string]string) map[string]string {
+	if headers == nil {
+		headers = make(map[string]string, len(extra))
+	}
+
+	for key, value := range extra {
+		headers[key] = value
+	}
+
+	return headers
+}
+
+// routeName identifies the matched API resource, e.g. "GET /api/task/{id}",
+// falling back to the raw path when API Gateway doesn't provide one.
+func routeName(req request) string {
+	resource := req.Resource
+	if resource == "" {
+		resource = req.Path
+	}
+
+	return req.HTTPMethod + " " + resource
+}
+
+// segmentName is routeName with path parameters rewritten to characters
+// X-Ray accepts in segment names.
+func segmentName(req request) string {
+	return strings.NewReplacer("{", ":", "}", "").Replace(routeName(req))
+}
+
+// safeDispatch turns a panic in a handler into a 500, so the response still
+// passes through router and gets its CORS headers.
+func (h *handler) safeDispatch(ctx context.Context, req request) (res events.APIGatewayProxyResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ctx := withLogAttrs(ctx, slog.String("stack", string(debug.Stack())))
+			res, err = serverError(ctx, &categorizedError{
+				category: categoryPanic,
+				err:      fmt.Errorf("handler panicked: %v", r),
+			})
+		}
+	}()
+
+	return h.dispatch(ctx, req)
+}
+
+func (h *handler) dispatch(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	httpMethod := req.HTTPMethod
+	path := req.Path
+
+	if authEnabled && requiresAuth(req) {
+		var err error
+		ctx, err = authenticate(ctx, req)
+		if err != nil {
+			return authError(ctx, err)
+		}
+	}
+
+	if rateLimitApplies(req) {
+		if res, limited := limitRate(ctx, req); limited {
+			return res, nil
+		}
+	}
+
+	if readOnly && isWrite(req) {
+		logger.WarnContext(ctx, "Rejected write in read-only mode")
+		res, err := clientErrorMessage(http.StatusServiceUnavailable, "service in read-only mode")
+		return withRetryAfter(res, readOnlyRetryAfter), err
+	}
+
+	if chaosApplies(req) {
+		if err := injectChaos(ctx); err != nil {
+			return serverError(ctx, err)
+		}
+	}
+
+	if unexpectedBody(req) {
+		logger.WarnContext(ctx, "Request has an unexpected body", slog.Int("body_bytes", len(req.Body)), slog.Bool("strict", strictBody), slog.String("error_category", categoryValidation))
+		if strictBody {
+			return clientErrorMessage(http.StatusBadRequest, fmt.Sprintf("%s requests don't take a body", httpMethod))
+		}
+	}
+
+	switch {
+	case httpMethod == "OPTIONS" && strings.HasPrefix(path, "/api/"):
+		return h.processOptions()
+	case httpMethod == "GET" && path == "/metrics" && metricsEndpoint:
+		return h.processMetrics()
+	case httpMethod == "GET" && path == "/api/health":
+		return h.processHealth(ctx)
+	case httpMethod == "GET" && path == "/api/ready":
+		return h.processReady(ctx)
+	case httpMethod == "GET" && path == "/api/version":
+		return h.processVersion(ctx)
+	case httpMethod == "GET" && path == "/api/openapi.json":
+		return h.processOpenAPI()
+	case httpMethod == "GET" && path == "/api/task":
+		return h.processGetTodos(ctx, req)
+	case httpMethod == "GET" && path == "/api/task/count":
+		return h.processCount(ctx)
+	case httpMethod == "GET" && path == "/api/task/export":
+		return h.processExport(ctx, req)
+	case httpMethod == "GET" && path == "/api/task/search":
+		return h.processSearch(ctx, req)
+	case httpMethod == "GET" && path == "/api/task/completed":
+		return h.processGetByStatus(ctx, req, true)
+	case httpMethod == "GET" && path == "/api/task/active":
+		return h.processGetByStatus(ctx, req, false)
+	case httpMethod == "GET" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/history"):
+		return withID(ctx, req, "/api/task/{id}/history", h.processHistory)
+	case httpMethod == "GET" && strings.HasPrefix(path, "/api/task/"):
+		return withID(ctx, req, "/api/task/{id}", h.processGetTodo)
+	case httpMethod == "POST" && path == "/api/task":
+		return h.processPost(ctx, req)
+	case httpMethod == "DELETE" && path == "/api/task":
+		return h.processDeleteAll(ctx)
+	case httpMethod == "PUT" && path == "/api/task/status":
+		return h.processPutStatuses(ctx, req)
+	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/status"):
+		return withID(ctx, req, "/api/task/{id}/status", h.processPutStatus)
+	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/position"):
+		return withID(ctx, req, "/api/task/{id}/position", h.processPosition)
+	case httpMethod == "POST" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/restore"):
+		return withID(ctx, req, "/api/task/{id}/restore", h.processRestore)
+	case httpMethod == "POST" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/toggle"):
+		return withID(ctx, req, "/api/task/{id}/toggle", h.processToggle)
+	case httpMethod == "POST" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/incomplete"):
+		return withID(ctx, req, "/api/task/{id}/incomplete", h.completeWith(false))
+	case httpMethod == "POST" && strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/complete"):
+		return withID(ctx, req, "/api/task/{id}/complete", h.completeWith(true))
+	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/task/"):
+		return withID(ctx, req, "/api/task/{id}", h.processPut)
+	case httpMethod == "PATCH" && strings.HasPrefix(path, "/api/task/"):
+		return withID(ctx, req, "/api/task/{id}", h.processPatch)
+	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/undoTask/"):
+		return withID(ctx, req, "/api/undoTask/{id}", h.processPut)
+	case httpMethod == "DELETE" && strings.HasPrefix(path, "/api/deleteTask/"):
+		return withID(ctx, req, "/api/deleteTask/{id}", h.processDelete)
+	default:
+		if methods := allowedMethods(path); methods != nil {
+			return methodNotAllowed(methods)
+		}
+
+		return clientError(http.StatusNotFound)
+	}
+}
+
+// readOnly rejects every write with a 503 while reads keep working, e.g.
+// during a data migration. Clients are asked to retry after
+// readOnlyRetryAfter.
+var readOnly = os.Getenv("READ_ONLY") == "true"
+
+var readOnlyRetryAfter = time.Duration(envInt("READ_ONLY_RETRY_AFTER_SECONDS", 60)) * time.Second
+
+// isWrite reports whether req is served by a route that modifies todos.
+// Requests for routes that don't exist aren't, so they still get a 404 or 405.
+func isWrite(req request) bool {
+	switch req.HTTPMethod {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return contains(allowedMethods(req.Path), req.HTTPMethod)
+	default:
+		return false
+	}
+}
+
+// strictBody rejects GET and DELETE requests that carry a body, which
+// dispatch otherwise only warns about, with a 400.
+var strictBody = os.Getenv("STRICT_BODY") == "true"
+
+// unexpectedBody reports whether req sends a body to a route that ignores
+// it, which hints at a client sending the wrong request.
+func unexpectedBody(req request) bool {
+	switch req.HTTPMethod {
+	case "GET", "DELETE":
+		return strings.TrimSpace(req.Body) != "" && contains(allowedMethods(req.Path), req.HTTPMethod)
+	default:
+		return false
+	}
+}
+
+// allowedMethods lists the methods dispatch serves for path, or nil when no
+// route exists for the path at all. Keep it in sync with dispatch.
+func allowedMethods(path string) []string {
+	switch {
+	case path == "/metrics" && metricsEndpoint:
+		return []string{"GET"}
+	case path == "/api/health", path == "/api/ready", path == "/api/version", path == "/api/openapi.json":
+		return []string{"GET", "OPTIONS"}
+	case path == "/api/task":
+		return []string{"GET", "POST", "DELETE", "OPTIONS"}
+	case path == "/api/task/count", path == "/api/task/export", path == "/api/task/search",
+		path == "/api/task/completed", path == "/api/task/active":
+		return []string{"GET", "OPTIONS"}
+	case path == "/api/task/status":
+		return []string{"PUT", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/status"),
+		strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/position"):
+		return []string{"PUT", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/restore"):
+		return []string{"POST", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/toggle"),
+		strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/complete"),
+		strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/incomplete"):
+		return []string{"POST", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/task/") && strings.HasSuffix(path, "/history"):
+		return []string{"GET", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/task/"):
+		return []string{"GET", "PUT", "PATCH", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/undoTask/"):
+		return []string{"PUT", "OPTIONS"}
+	case strings.HasPrefix(path, "/api/deleteTask/"):
+		return []string{"DELETE", "OPTIONS"}
+	default:
+		return nil
+	}
+}
+
+func methodNotAllowed(methods []string) (events.APIGatewayProxyResponse, error) {
+	res, err := clientError(http.StatusMethodNotAllowed)
+	res.Headers = mergeHeaders(res.Headers, map[string]string{
+		"Allow": strings.Join(methods, ", "),
+	})
+
+	return res, err
+}
+
+// processOptions answers CORS preflight requests. The origin and allowed
+// headers are added by router like on every other response.
+func (h *handler) processOptions() (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Access-Control-Allow-Methods": corsAllowMethods,
+			"Access-Control-Max-Age":       strconv.Itoa(corsMaxAge),
+		},
+	}, nil
+}
+
+func (h *handler) processMetrics() (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type": "text/plain; version=0.0.4",
+		},
+		Body: localMetrics.prometheus(),
+	}, nil
+}
+
+func (h *handler) processHealth(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	return healthResponse(ctx, http.StatusOK, "ok")
+}
+
+func (h *handler) processReady(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	err := h.store.Ping(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Table is not reachable", slog.String("error", err.Error()))
+		res, err := healthResponse(ctx, http.StatusServiceUnavailable, "unavailable")
+		return withRetryAfter(res, defaultRetryAfter), err
+	}
+
+	return healthResponse(ctx, http.StatusOK, "ok")
+}
+
+func healthResponse(ctx context.Context, status int, text string) (events.APIGatewayProxyResponse, error) {
+	return dataResponse(ctx, status, HealthStatus{
+		Status: text,
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (h *handler) processCount(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received count request")
+
+	total, completed, err := h.store.Count(ctx)
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	logger.InfoContext(ctx, "Successfully counted todos", slog.Int("total", total), slog.Int("completed", completed))
+
+	return dataResponse(ctx, http.StatusOK, TodoCount{
+		Total:     total,
+		Completed: completed,
+	})
+}
+
+// searchMinQuery is the shortest query accepted, since shorter ones match
+// nearly every todo.
+const searchMinQuery = 2
+
+func (h *handler) processSearch(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	query := strings.TrimSpace(req.QueryStringParameters["q"])
+	if len([]rune(query)) < searchMinQuery {
+		logger.InfoContext(ctx, "Invalid search query", slog.String("q", logText(query)), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, fmt.Sprintf("q must be at least %d characters", searchMinQuery))
+	}
+
+	// Like lists, searches page with ?limit and ?cursor. The next page link
+	// keeps q, since the cursor is only a position in the table.
+	limit, err := pageLimit(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid limit", slog.String("limit", req.QueryStringParameters["limit"]), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	opts := SearchOptions{
+		Query:  query,
+		Limit:  limit,
+		Cursor: req.QueryStringParameters["cursor"],
+	}
+	logger.InfoContext(ctx, "Received search request", slog.String("q", logText(query)), slog.Int("limit", opts.Limit), slog.String("cursor", opts.Cursor))
+
+	todos, nextCursor, err := h.store.Search(ctx, opts)
+	if errors.Is(err, ErrInvalidCursor) {
+		logger.InfoContext(ctx, "Invalid cursor", slog.String("cursor", opts.Cursor), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, "invalid or expired cursor")
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	logger.InfoContext(ctx, "Successfully searched todos", slog.Int("count", len(todos)), slog.String("next_cursor", nextCursor))
+
+	res, err := dataResponse(ctx, http.StatusOK, SearchResult{
+		Items:      todos,
+		NextCursor: nextCursor,
+		Truncated:  nextCursor != "",
+	})
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	if nextCursor != "" {
+		res.Headers["Link"] = fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(ctx, req, nextCursor))
+	}
+
+	return res, nil
 }
 
-var validate *validator.Validate = validator.New()
+func (h *handler) processGetTodo(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received GET todo request", slog.String("id", id))
+
+	fields, err := parseFields(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid fields", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, err.Error())
+	}
+
+	consistent, err := consistentRead(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid consistent", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	todo, err := h.store.Get(ctx, id, GetOptions{
+		Fields:     withRequiredFields(fields, "id", "deleted", "version"),
+		Consistent: consistent,
+	})
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	if todo.Deleted {
+		return clientError(http.StatusNotFound)
+	}
+
+	etag := todoETag(todo)
+	if etagMatches(requestHeader(req, "If-None-Match"), etag) {
+		logger.InfoContext(ctx, "Todo not modified", slog.String("id", id), slog.String("etag", etag))
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusNotModified,
+			Headers: map[string]string{
+				"ETag": etag,
+			},
+		}, nil
+	}
+
+	logger.InfoContext(ctx, "Successfully fetched todo item", slog.Any("todo", todo))
+
+	var body any = todo
+	if fields != nil {
+		body = projectTodo(*todo, fields)
+	}
+
+	response, err := dataResponse(ctx, http.StatusOK, body)
+	if response.StatusCode == http.StatusOK {
+		response.Headers["ETag"] = etag
+	}
+
+	return response, err
+}
+
+// todoFields are the fields ?fields can select.
+var todoFields = map[string]bool{
+	"id":        true,
+	"task":      true,
+	"status":    true,
+	"createdAt": true,
+	"updatedAt": true,
+	"version":   true,
+	"deleted":   true,
+	"deletedAt": true,
+	"expiresAt": true,
+	"position":  true,
+}
+
+// parseFields reads the comma-separated ?fields parameter, e.g. "id,task".
+// It returns nil when the parameter is absent.
+func parseFields(req request) ([]string, error) {
+	value, ok := req.QueryStringParameters["fields"]
+	if !ok {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if !todoFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// withRequiredFields adds the fields a handler needs itself to the ones a
+// client selected. Nil fields stay nil, which reads every field.
+func withRequiredFields(fields []string, required ...string) []string {
+	if fields == nil {
+		return nil
+	}
+
+	return append(append([]string(nil), fields...), required...)
+}
+
+// projectTodo keeps only fields of todo, leaving out empty ones that Todo
+// omits as well.
+func projectTodo(todo Todo, fields []string) map[string]any {
+	// A Todo always marshals.
+	data, _ := json.Marshal(todo)
+	var all map[string]any
+	json.Unmarshal(data, &all)
+
+	projected := make(map[string]any, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			projected[field] = value
+		}
+	}
+
+	return projected
+}
+
+// todoETag is the todo's version as a strong entity tag, so it can be sent
+// back unchanged in If-Match. Every write to a todo bumps its version.
+func todoETag(todo *Todo) string {
+	return fmt.Sprintf(`"%d"`, todo.Version)
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Comparison is weak, as RFC 9110 requires for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// processGetByStatus serves /api/task/completed and /api/task/active, which
+// are the todo list with its status filter fixed.
+func (h *handler) processGetByStatus(ctx context.Context, req request, status bool) (events.APIGatewayProxyResponse, error) {
+	query := make(map[string]string, len(req.QueryStringParameters)+1)
+	for key, value := range req.QueryStringParameters {
+		query[key] = value
+	}
+	query["status"] = strconv.FormatBool(status)
+	req.QueryStringParameters = query
+
+	return h.processGetTodos(ctx, req)
+}
+
+func (h *handler) processGetTodos(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	limit, err := pageLimit(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid limit", slog.String("limit", req.QueryStringParameters["limit"]), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	opts := ListOptions{
+		Limit:  limit,
+		Cursor: req.QueryStringParameters["cursor"],
+	}
+
+	if v, ok := req.QueryStringParameters["status"]; ok {
+		if v != "true" && v != "false" {
+			logger.InfoContext(ctx, "Invalid status", slog.String("status", v), slog.String("error_category", categoryValidation))
+			return clientError(http.StatusBadRequest)
+		}
+		status := v == "true"
+		opts.Status = &status
+	}
+
+	consistent, err := consistentRead(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid consistent", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+	opts.Consistent = consistent
+
+	if v, ok := req.QueryStringParameters["includeDeleted"]; ok {
+		if v != "true" && v != "false" {
+			logger.InfoContext(ctx, "Invalid includeDeleted", slog.String("includeDeleted", v), slog.String("error_category", categoryValidation))
+			return clientError(http.StatusBadRequest)
+		}
+		opts.IncludeDeleted = v == "true"
+	}
+
+	withCount := false
+	if v, ok := req.QueryStringParameters["count"]; ok {
+		if v != "true" && v != "false" {
+			logger.InfoContext(ctx, "Invalid count", slog.String("count", v), slog.String("error_category", categoryValidation))
+			return clientError(http.StatusBadRequest)
+		}
+		withCount = v == "true"
+	}
+
+	// sort=position pages through the todos in the order they were arranged
+	// in, from the position index. The other sorts order each page.
+	sortBy, ok := req.QueryStringParameters["sort"]
+	if ok && sortBy != "createdAt" && sortBy != "task" && sortBy != "position" {
+		logger.InfoContext(ctx, "Invalid sort", slog.String("sort", sortBy), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusBadRequest)
+	}
+
+	fields, err := parseFields(req)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid fields", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, err.Error())
+	}
+
+	// Sorting by createdAt or task happens here, so the sort field has to be
+	// read too. A CSV export has its own fixed columns.
+	exportCSV := prefersCSV(requestHeader(req, "Accept"))
+	opts.Fields = fields
+	switch sortBy {
+	case "createdAt", "task":
+		opts.Fields = withRequiredFields(fields, sortBy)
+	case "position":
+		opts.ByPosition = true
+	}
+	if exportCSV {
+		opts.Fields = nil
+	}
+	logger.InfoContext(ctx, "Received GET todos request", slog.Any("options", opts), slog.String("sort", sortBy), slog.Bool("csv", exportCSV))
+
+	if exportCSV {
+		return h.listCSV(ctx, req, opts, sortBy)
+	}
+
+	todos, nextCursor, err := h.store.List(ctx, opts)
+	if errors.Is(err, ErrInvalidCursor) {
+		logger.InfoContext(ctx, "Invalid cursor", slog.String("cursor", opts.Cursor), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, "invalid or expired cursor")
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	sortTodos(todos, sortBy)
+	logger.InfoContext(ctx, "Successfully fetched todos", slog.Int("count", len(todos)), slog.String("next_cursor", nextCursor))
+
+	var items any = todos
+	if fields != nil {
+		projected := make([]map[string]any, 0, len(todos))
+		for _, todo := range todos {
+			projected = append(projected, projectTodo(todo, fields))
+		}
+		items = projected
+	}
+
+	res, err := dataResponse(ctx, http.StatusOK, TodoPage{
+		Items:      items,
+		NextCursor: nextCursor,
+	})
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+
+	if nextCursor != "" {
+		res.Headers["Link"] = fmt.Sprintf("<%s>; rel=\"next\"", nextPageURL(ctx, req, nextCursor))
+	}
+
+	if withCount {
+		total, err := h.totalCount(ctx, opts)
+		if err != nil {
+			return serverError(ctx, err)
+		}
+
+		if total >= 0 {
+			res.Headers["X-Total-Count"] = strconv.Itoa(total)
+		}
+	}
+
+	return withVaryAccept(res), err
+}
+
+// sortTodos orders a page of todos by the ?sort of a list. Pages sorted by
+// position come in order from the store.
+func sortTodos(todos []Todo, sortBy string) {
+	switch sortBy {
+	case "createdAt":
+		sort.SliceStable(todos, func(i, j int) bool { return todos[i].CreatedAt < todos[j].CreatedAt })
+	case "task":
+		sort.SliceStable(todos, func(i, j int) bool { return todos[i].Task < todos[j].Task })
+	}
+}
+
+// pageLimit returns the page size ?limit asks for, clamped to maxPageSize.
+func pageLimit(req request) (int, error) {
+	v, ok := req.QueryStringParameters["limit"]
+	if !ok {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit %q", v)
+	}
+
+	switch {
+	case limit > maxPageSize:
+		return maxPageSize, nil
+	case limit > 0:
+		return limit, nil
+	default:
+		return defaultPageSize, nil
+	}
+}
+
+// nextPageURL is the URL of req with cursor in place of its own.
+func nextPageURL(ctx context.Context, req request, cursor string) string {
+	query := url.Values{}
+	for key, value := range req.QueryStringParameters {
+		query.Set(key, value)
+	}
+	query.Set("cursor", cursor)
+
+	return fmt.Sprintf("%s%s?%s", baseURLFrom(ctx), req.Path, query.Encode())
+}
+
+// totalCount is how many todos match opts across all pages, for the
+// X-Total-Count header of ?count=true. It costs a Count call, which scans the
+// table, so lists only include it when asked. Count leaves out soft deleted
+// todos, so it returns -1 for lists that include them.
+func (h *handler) totalCount(ctx context.Context, opts ListOptions) (int, error) {
+	if opts.IncludeDeleted {
+		return -1, nil
+	}
+
+	total, completed, err := h.store.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case opts.Status == nil:
+		return total, nil
+	case *opts.Status:
+		return completed, nil
+	default:
+		return total - completed, nil
+	}
+}
+
+// withVaryAccept marks a response whose format depends on the Accept header.
+func withVaryAccept(res events.APIGatewayProxyResponse) events.APIGatewayProxyResponse {
+	res.Headers = mergeHeaders(res.Headers, map[string]string{
+		"Vary": addVary(res.Headers["Vary"], "Accept"),
+	})
+
+	return res
+}
+
+func (h *handler) processPost(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return bodyError(ctx, err)
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return bodyError(ctx, errEmptyBody)
+	}
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		return h.processPostBatch(ctx, body, isDryRun(req))
+	}
+
+	if fields := schemaViolations("CreateTodo", body, nil); fields != nil {
+		return schemaError(ctx, fields)
+	}
+
+	var createTodo CreateTodo
+	err = decodeBody(body, &createTodo)
+	if err != nil {
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
+	}
+
+	createTodo.Task = strings.TrimSpace(createTodo.Task)
+	err = validate.Struct(&createTodo)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return validationError(ctx, fieldErrors(err, nil))
+	}
+	logger.InfoContext(ctx, "Received POST request", slog.Any("item", createTodo))
+
+	if createTodo.Id != "" && !allowClientIDs {
+		return clientErrorMessage(http.StatusBadRequest, "id is assigned by the server")
+	}
+
+	if isDryRun(req) {
+		todo := newTodo(ctx, createTodo, uuid.NewString)
+		logger.InfoContext(ctx, "Dry run, skipping insert", slog.Any("todo", todo))
+		return dataResponse(ctx, http.StatusOK, DryRunTodo{Todo: &todo, DryRun: true})
+	}
+
+	idempotencyKey := requestHeader(req, "Idempotency-Key")
+	if idempotencyKey != "" {
+		return h.processPostIdempotent(ctx, createTodo, idempotencyKey)
+	}
+
+	res, err := h.store.Insert(ctx, createTodo)
+	if errors.Is(err, ErrDuplicateID) {
+		return duplicateIDError(ctx, createTodo.Id)
+	}
+
+	if errors.Is(err, ErrDuplicateTask) {
+		return duplicateTaskError(ctx, createTodo.Task)
+	}
+
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	logger.InfoContext(ctx, "Inserted new todo", slog.Any("todo", res))
+	h.recordHistory(ctx, actionCreate, *res)
+
+	return todoResponse(ctx, http.StatusCreated, res)
+}
+
+func (h *handler) processPostIdempotent(ctx context.Context, createTodo CreateTodo, key string) (events.APIGatewayProxyResponse, error) {
+	res, created, err := h.store.InsertIdempotent(ctx, createTodo, key)
+	if errors.Is(err, ErrDuplicateID) {
+		return duplicateIDError(ctx, createTodo.Id)
+	}
+
+	if errors.Is(err, ErrDuplicateTask) {
+		return duplicateTaskError(ctx, createTodo.Task)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		logger.InfoContext(ctx, "Todo created with idempotency key no longer exists", slog.String("idempotency_key", key))
+		return clientError(http.StatusConflict)
+	}
+
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	if !created {
+		logger.InfoContext(ctx, "Returned todo from earlier request", slog.String("idempotency_key", key), slog.Any("todo", res))
+		return todoResponse(ctx, http.StatusOK, res)
+	}
+	logger.InfoContext(ctx, "Inserted new todo", slog.String("idempotency_key", key), slog.Any("todo", res))
+	h.recordHistory(ctx, actionCreate, *res)
+
+	return todoResponse(ctx, http.StatusCreated, res)
+}
+
+// allowClientIDs lets POST /api/task choose the id of the todo it creates, so
+// that clients can create todos offline and sync them later. Ids must be
+// UUIDs, and one that is already taken is rejected with a 409.
+var allowClientIDs = os.Getenv("ALLOW_CLIENT_IDS") == "true"
+
+func duplicateIDError(ctx context.Context, id string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Todo id already exists", slog.String("id", id))
+	return clientErrorMessage(http.StatusConflict, "a todo with this id already exists")
+}
+
+func duplicateTaskError(ctx context.Context, task string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Todo task already exists", slog.String("task", logText(task)))
+	return clientErrorMessage(http.StatusConflict, "a todo with this task already exists")
+}
+
+func todoResponse(ctx context.Context, status int, todo *Todo) (events.APIGatewayProxyResponse, error) {
+	response, err := dataResponse(ctx, status, todo)
+	if response.StatusCode == status {
+		response.Headers["Location"] = fmt.Sprintf("%s/api/task/%s", baseURLFrom(ctx), todo.Id)
+	}
+
+	return response, err
+}
+
+func (h *handler) processPostBatch(ctx context.Context, body []byte, dryRun bool) (events.APIGatewayProxyResponse, error) {
+	if fields := batchSchemaViolations("CreateTodo", body); fields != nil {
+		return schemaError(ctx, fields)
+	}
+
+	var createTodos []CreateTodo
+	err := decodeBody(body, &createTodos)
+	if err != nil {
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
+	}
+
+	if len(createTodos) == 0 {
+		return clientErrorMessage(http.StatusBadRequest, "at least one item is required")
+	}
+
+	for i := range createTodos {
+		createTodos[i].Task = strings.TrimSpace(createTodos[i].Task)
+		err = validate.Struct(&createTodos[i])
+		if err != nil {
+			logger.InfoContext(ctx, "Invalid body", slog.Int("index", i), slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+			return validationError(ctx, fieldErrors(err, &i))
+		}
+
+		// Batch writes can't be conditional, so duplicates couldn't be told apart.
+		if createTodos[i].Id != "" {
+			return clientErrorMessage(http.StatusBadRequest, "ids can't be chosen in batch creates")
+		}
+	}
+
+	// For the same reason, batches can't keep tasks unique.
+	if uniqueTasks {
+		return clientErrorMessage(http.StatusBadRequest, "batch creates are unavailable while tasks must be unique")
+	}
+	logger.InfoContext(ctx, "Received batch POST request", slog.Int("count", len(createTodos)))
+
+	if dryRun {
+		todos := make([]Todo, 0, len(createTodos))
+		for _, createTodo := range createTodos {
+			todos = append(todos, newTodo(ctx, createTodo, uuid.NewString))
+		}
+		logger.InfoContext(ctx, "Dry run, skipping batch insert", slog.Int("count", len(todos)))
+
+		return dataResponse(ctx, http.StatusOK, DryRunBatch{Items: todos, DryRun: true})
+	}
+
+	res, err := h.store.BatchInsert(ctx, createTodos)
+	if err != nil {
+		return serverError(ctx, err)
+	}
+	logger.InfoContext(ctx, "Inserted new todos", slog.Int("count", len(res)))
+	for _, todo := range res {
+		h.recordHistory(ctx, actionCreate, todo)
+	}
+
+	return dataResponse(ctx, http.StatusCreated, res)
+}
+
+func (h *handler) processDelete(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	// Deletes are soft unless ?hard=true, so todos can be restored.
+	hard := req.QueryStringParameters["hard"] == "true"
+	logger.InfoContext(ctx, "Received DELETE request", slog.String("id", id), slog.Bool("hard", hard))
+
+	if isDryRun(req) {
+		return h.previewDelete(ctx, id, hard)
+	}
+
+	var todo *Todo
+	var err error
+	if hard {
+		todo, err = h.store.Delete(ctx, id)
+	} else {
+		todo, err = h.store.SoftDelete(ctx, id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	logger.InfoContext(ctx, "Successfully deleted todo item", slog.Any("todo", todo))
+	if hard {
+		h.recordHistory(ctx, actionHardDelete, *todo)
+	} else {
+		h.recordHistory(ctx, actionDelete, *todo)
+	}
+
+	return dataResponse(ctx, http.StatusOK, todo)
+}
+
+func (h *handler) processRestore(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received restore request", slog.String("id", id))
+
+	todo, err := h.store.Restore(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	logger.InfoContext(ctx, "Successfully restored todo item", slog.Any("todo", todo))
+	h.recordHistory(ctx, actionRestore, *todo)
+
+	return dataResponse(ctx, http.StatusOK, todo)
+}
+
+func (h *handler) processDeleteAll(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	if !allowBulkDelete {
+		logger.WarnContext(ctx, "Rejected bulk delete because ALLOW_BULK_DELETE is not set")
+		return clientError(http.StatusForbidden)
+	}
+	logger.InfoContext(ctx, "Received bulk DELETE request")
+
+	deleted, err := h.store.DeleteAll(ctx)
+	if err != nil {
+		logger.ErrorContext(ctx, "Bulk delete stopped early", slog.Int("deleted", deleted))
+		return serverError(ctx, err)
+	}
+	logger.InfoContext(ctx, "Successfully deleted all todo items", slog.Int("deleted", deleted))
+
+	return dataResponse(ctx, http.StatusOK, DeleteAllResult{Deleted: deleted})
+}
+
+func (h *handler) processPut(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+
+	var updateTodo UpdateTodo
+
+	path := req.Path
+
+	if strings.HasPrefix(path, "/api/task/") {
+		body, err := requestBody(req)
+		if err != nil {
+			return bodyError(ctx, err)
+		}
+
+		if len(bytes.TrimSpace(body)) > 0 {
+			return h.processReplace(ctx, req, id, body)
+		}
+	}
+
+	successor := "complete"
+	switch {
+	case strings.HasPrefix(path, "/api/task/"):
+		updateTodo = UpdateTodo{Status: true}
+	case strings.HasPrefix(path, "/api/undoTask/"):
+		updateTodo = UpdateTodo{Status: false}
+		successor = "incomplete"
+	}
+	logger.WarnContext(ctx, "Deprecated route called", slog.String("successor", "POST /api/task/{id}/"+successor))
+
+	// These routes predate versions, so like their successors they only
+	// update conditionally when the client sends If-Match.
+	version := anyVersion
+	if requestHeader(req, "If-Match") != "" {
+		var err error
+		version, err = expectedVersion(req, nil)
+		if err != nil {
+			return versionError(ctx, err)
+		}
+	}
+
+	var res events.APIGatewayProxyResponse
+	var err error
+	switch {
+	case isDryRun(req):
+		res, err = h.previewUpdate(ctx, id, version, func(todo *Todo) {
+			todo.Status = updateTodo.Status
+		})
+	case version == anyVersion:
+		res, err = h.processComplete(ctx, req, id, updateTodo.Status)
+	default:
+		res, err = h.updateStatus(ctx, id, updateTodo, version)
+	}
+
+	res.Headers = mergeHeaders(res.Headers, map[string]string{
+		"Deprecation": "true",
+		"Link":        fmt.Sprintf("<%s/api/task/%s/%s>; rel=\"successor-version\"", baseURLFrom(ctx), id, successor),
+	})
+
+	return res, err
+}
+
+// completeWith adapts processComplete to an idHandler that sets status.
+func (h *handler) completeWith(status bool) idHandler {
+	return func(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+		return h.processComplete(ctx, req, id, status)
+	}
+}
+
+// processComplete serves POST /api/task/{id}/complete and /incomplete, which
+// set the status to what they're named after. An If-Match header makes the
+// update conditional on the version, like PUT; without one it always applies.
+func (h *handler) processComplete(ctx context.Context, req request, id string, status bool) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received complete request", slog.String("id", id), slog.Bool("status", status))
+
+	if requestHeader(req, "If-Match") != "" {
+		version, err := expectedVersion(req, nil)
+		if err != nil {
+			return versionError(ctx, err)
+		}
+
+		return h.updateStatus(ctx, id, UpdateTodo{Status: status}, version)
+	}
+
+	change := h.store.SetStatuses(ctx, []string{id}, status)[0]
+	if errors.Is(change.Err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if change.Err != nil {
+		return serverError(ctx, change.Err)
+	}
+
+	if change.Unchanged {
+		logger.InfoContext(ctx, "Coalesced redundant status update", slog.String("id", id), slog.Bool("status", status))
+		return todoResponse(ctx, http.StatusOK, change.Todo)
+	}
+
+	logger.InfoContext(ctx, "Updated todo", slog.Any("todo", change.Todo))
+	h.recordHistory(ctx, actionStatus, *change.Todo)
+
+	return todoResponse(ctx, http.StatusOK, change.Todo)
+}
+
+// processReplace handles a PUT carrying a body. A body with both task and
+// status replaces the two atomically. Anything less keeps the legacy
+// behaviour of marking the todo as done.
+func (h *handler) processReplace(ctx context.Context, req request, id string, body []byte) (events.APIGatewayProxyResponse, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		logger.InfoContext(ctx, "Can't unmarshal body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return clientError(http.StatusUnprocessableEntity)
+	}
+
+	_, hasTask := fields["task"]
+	_, hasStatus := fields["status"]
+	if !hasTask || !hasStatus {
+		version, err := expectedVersion(req, nil)
+		if err != nil {
+			return versionError(ctx, err)
+		}
+
+		return h.updateStatus(ctx, id, UpdateTodo{Status: true}, version)
+	}
+
+	if fields := schemaViolations("ReplaceTodo", body, nil); fields != nil {
+		return schemaError(ctx, fields)
+	}
 
-func router(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	log.Printf("Received req %#v", req)
+	var replaceTodo ReplaceTodo
+	err = decodeBody(body, &replaceTodo)
+	if err != nil {
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
+	}
 
-	httpMethod := req.HTTPMethod
-	path := req.Path
+	replaceTodo.Task = strings.TrimSpace(replaceTodo.Task)
+	err = validate.Struct(&replaceTodo)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return validationError(ctx, fieldErrors(err, nil))
+	}
+	logger.InfoContext(ctx, "Received PUT replace request", slog.String("id", id), slog.Any("item", replaceTodo))
 
-	switch {
-	case httpMethod == "GET" && path == "/api/task":
-		return processGet(ctx, req)
-	case httpMethod == "POST" && path == "/api/task":
-		return processPost(ctx, req)
-	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/task/"):
-		return processPut(ctx, req)
-	case httpMethod == "PUT" && strings.HasPrefix(path, "/api/undoTask/"):
-		return processPut(ctx, req)
-	case httpMethod == "DELETE" && strings.HasPrefix(path, "/api/deleteTask/"):
-		return processDelete(ctx, req)
-	default:
-		return events.APIGatewayProxyResponse{
-			StatusCode: 404,
-			Body:       "Not Found",
-		}, nil
+	version, err := expectedVersion(req, replaceTodo.Version)
+	if err != nil {
+		return versionError(ctx, err)
+	}
+
+	if isDryRun(req) {
+		return h.previewUpdate(ctx, id, version, func(todo *Todo) {
+			todo.Task = replaceTodo.Task
+			todo.Status = replaceTodo.Status
+		})
+	}
+
+	res, err := h.store.Replace(ctx, id, replaceTodo, version)
+	if errors.Is(err, ErrVersionConflict) {
+		logger.InfoContext(ctx, "Todo was modified concurrently", slog.String("id", id), slog.Int("version", version))
+		return clientError(http.StatusConflict)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
 	}
 
+	logger.InfoContext(ctx, "Replaced todo", slog.Any("todo", res))
+	h.recordHistory(ctx, actionReplace, *res)
+
+	return todoResponse(ctx, http.StatusOK, res)
 }
 
-func processGet(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	id, ok := req.PathParameters["id"]
+// consistentReads makes GET requests read strongly consistent by default.
+// Without it, a todo read right after being written may come back stale.
+// Consistent reads cost twice the read capacity, so the default is off and
+// clients that need read-your-writes ask with ?consistent=true.
+var consistentReads = os.Getenv("CONSISTENT_READS") == "true"
+
+// consistentRead reports whether req reads consistently, from ?consistent or
+// else consistentReads.
+func consistentRead(req request) (bool, error) {
+	v, ok := req.QueryStringParameters["consistent"]
 	if !ok {
-		return processGetTodos(ctx)
-	} else {
-		return processGetTodo(ctx, id)
+		return consistentReads, nil
 	}
+
+	if v != "true" && v != "false" {
+		return false, fmt.Errorf("consistent must be true or false, got %q", v)
+	}
+
+	return v == "true", nil
+}
+
+// isDryRun reports whether the client asked to preview a mutation with
+// ?dryRun=true. Dry runs read and validate as usual but never write.
+func isDryRun(req request) bool {
+	return req.QueryStringParameters["dryRun"] == "true"
 }
 
-func processGetTodo(ctx context.Context, id string) (events.APIGatewayProxyResponse, error) {
-	log.Printf("Received GET todo request with id = %s", id)
+// previewUpdate applies change to the stored todo in memory, after the same
+// existence and version checks the conditional update would make. With
+// anyVersion, it previews an unconditional update.
+func (h *handler) previewUpdate(ctx context.Context, id string, version int, change func(*Todo)) (events.APIGatewayProxyResponse, error) {
+	todo, err := h.store.Get(ctx, id, GetOptions{Consistent: true})
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	if version != anyVersion && todo.Version != version {
+		logger.InfoContext(ctx, "Todo was modified concurrently", slog.String("id", id), slog.Int("version", version))
+		return clientError(http.StatusConflict)
+	}
+
+	change(todo)
+	todo.UpdatedAt = now()
+	todo.Version++
+	logger.InfoContext(ctx, "Dry run, skipping update", slog.Any("todo", todo))
 
-	todo, err := getItem(ctx, id)
+	return dataResponse(ctx, http.StatusOK, DryRunTodo{Todo: todo, DryRun: true})
+}
+
+// previewDelete returns the todo a soft or hard delete would leave behind.
+func (h *handler) previewDelete(ctx context.Context, id string, hard bool) (events.APIGatewayProxyResponse, error) {
+	todo, err := h.store.Get(ctx, id, GetOptions{Consistent: true})
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
 	if err != nil {
-		return serverError(err)
+		return serverError(ctx, err)
 	}
 
-	if todo == nil {
+	// SoftDelete only matches todos that aren't deleted yet.
+	if !hard && todo.Deleted {
 		return clientError(http.StatusNotFound)
 	}
 
-	json, err := json.Marshal(todo)
-	if err != nil {
-		return serverError(err)
+	if !hard {
+		current := now()
+		todo.Deleted = true
+		todo.DeletedAt = current
+		todo.UpdatedAt = current
+		todo.Version++
 	}
-	log.Printf("Successfully fetched todo item %s", json)
+	logger.InfoContext(ctx, "Dry run, skipping delete", slog.Any("todo", todo))
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-		},
-		Body: string(json),
-	}, nil
+	return dataResponse(ctx, http.StatusOK, DryRunTodo{Todo: todo, DryRun: true})
 }
 
-func processGetTodos(ctx context.Context) (events.APIGatewayProxyResponse, error) {
-	log.Print("Received GET todos request")
+func (h *handler) processPutStatuses(ctx context.Context, req request) (events.APIGatewayProxyResponse, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return bodyError(ctx, err)
+	}
+
+	if fields := schemaViolations("BatchStatusUpdate", body, nil); fields != nil {
+		return schemaError(ctx, fields)
+	}
 
-	todos, err := listItems(ctx)
+	var update BatchStatusUpdate
+	err = decodeBody(body, &update)
 	if err != nil {
-		return serverError(err)
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
 	}
 
-	json, err := json.Marshal(todos)
+	err = validate.Struct(&update)
 	if err != nil {
-		return serverError(err)
+		logger.InfoContext(ctx, "Invalid body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return validationError(ctx, fieldErrors(err, nil))
 	}
-	log.Printf("Successfully fetched todos: %s", json)
+	logger.InfoContext(ctx, "Received batch status request", slog.Int("count", len(update.Ids)), slog.Bool("status", *update.Status))
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-		},
-		Body: string(json),
-	}, nil
+	result := BatchStatusResult{
+		Updated:  make([]Todo, 0, len(update.Ids)),
+		NotFound: make([]string, 0),
+		Failed:   make([]string, 0),
+	}
+	for _, change := range h.store.SetStatuses(ctx, update.Ids, *update.Status) {
+		switch {
+		case errors.Is(change.Err, ErrNotFound):
+			result.NotFound = append(result.NotFound, change.Id)
+		case change.Err != nil:
+			logger.ErrorContext(ctx, "Can't update todo status", slog.String("id", change.Id), slog.String("error", change.Err.Error()))
+			result.Failed = append(result.Failed, change.Id)
+		case change.Unchanged:
+			logger.InfoContext(ctx, "Coalesced redundant status update", slog.String("id", change.Id), slog.Bool("status", *update.Status))
+			result.Updated = append(result.Updated, *change.Todo)
+		default:
+			result.Updated = append(result.Updated, *change.Todo)
+			h.recordHistory(ctx, actionStatus, *change.Todo)
+		}
+	}
+	logger.InfoContext(ctx, "Updated todo statuses",
+		slog.Int("updated", len(result.Updated)),
+		slog.Int("not_found", len(result.NotFound)),
+		slog.Int("failed", len(result.Failed)),
+	)
+
+	return dataResponse(ctx, http.StatusOK, result)
 }
 
-func processPost(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	var createTodo CreateTodo
-	err := json.Unmarshal([]byte(req.Body), &createTodo)
+func (h *handler) processPutStatus(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+
+	body, err := requestBody(req)
 	if err != nil {
-		log.Printf("Can't unmarshal body: %v", err)
-		return clientError(http.StatusUnprocessableEntity)
+		return bodyError(ctx, err)
 	}
 
-	err = validate.Struct(&createTodo)
+	if fields := schemaViolations("UpdateTodo", body, nil); fields != nil {
+		return schemaError(ctx, fields)
+	}
+
+	var updateTodo UpdateTodo
+	err = decodeBody(body, &updateTodo)
 	if err != nil {
-		log.Printf("Invalid body: %v", err)
-		return clientError(http.StatusBadRequest)
+		return decodeError(ctx, err, http.StatusBadRequest)
 	}
-	log.Printf("Received POST request with item: %+v", createTodo)
+	logger.InfoContext(ctx, "Received PUT status request", slog.String("id", id), slog.Any("item", updateTodo))
 
-	res, err := insertItem(ctx, createTodo)
+	version, err := expectedVersion(req, updateTodo.Version)
 	if err != nil {
-		return serverError(err)
+		return versionError(ctx, err)
 	}
-	log.Printf("Inserted new todo: %+v", res)
 
-	json, err := json.Marshal(res)
+	return h.updateStatus(ctx, id, updateTodo, version)
+}
+
+// updateStatus sets the status of the todo with id at version. A version
+// conflict with a todo that already has the status is most likely the same
+// request sent twice, e.g. by a double click, and is answered with the todo
+// as it is instead of a 409.
+func (h *handler) updateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (events.APIGatewayProxyResponse, error) {
+	res, err := h.store.UpdateStatus(ctx, id, updateTodo, version)
+	if errors.Is(err, ErrVersionConflict) {
+		current, getErr := h.store.Get(ctx, id, GetOptions{Consistent: true})
+		if getErr == nil && !current.Deleted && current.Status == updateTodo.Status {
+			logger.InfoContext(ctx, "Coalesced redundant status update", slog.String("id", id), slog.Bool("status", updateTodo.Status), slog.Int("version", version))
+			return todoResponse(ctx, http.StatusOK, current)
+		}
+
+		logger.InfoContext(ctx, "Todo was modified concurrently", slog.String("id", id), slog.Int("version", version))
+		return clientError(http.StatusConflict)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
 	if err != nil {
-		return serverError(err)
+		return serverError(ctx, err)
 	}
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusCreated,
-		Body:       string(json),
-		Headers: map[string]string{
-			"Location":                     fmt.Sprintf("/todo/%s", res.Id),
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-		},
-	}, nil
+	logger.InfoContext(ctx, "Updated todo", slog.Any("todo", res))
+	h.recordHistory(ctx, actionStatus, *res)
+
+	return todoResponse(ctx, http.StatusOK, res)
 }
 
-func processDelete(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	id, ok := req.PathParameters["id"]
-	if !ok {
-		return clientError(http.StatusBadRequest)
+// processToggle flips a todo's status without the client having to know it,
+// which suits a checkbox.
+func (h *handler) processToggle(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received toggle request", slog.String("id", id))
+
+	res, err := h.store.Toggle(ctx, id)
+	if errors.Is(err, ErrVersionConflict) {
+		logger.InfoContext(ctx, "Todo status kept changing", slog.String("id", id))
+		return clientError(http.StatusConflict)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
 	}
-	log.Printf("Received DELETE request with id = %s", id)
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	logger.InfoContext(ctx, "Toggled todo", slog.Any("todo", res))
+	h.recordHistory(ctx, actionStatus, *res)
+
+	return todoResponse(ctx, http.StatusOK, res)
+}
+
+func (h *handler) processPatch(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
 
-	todo, err := deleteItem(ctx, id)
+	body, err := requestBody(req)
 	if err != nil {
-		return serverError(err)
+		return bodyError(ctx, err)
 	}
 
-	if todo == nil {
-		return clientError(http.StatusNotFound)
+	if fields := schemaViolations("PatchTodo", body, nil); fields != nil {
+		return schemaError(ctx, fields)
 	}
 
-	json, err := json.Marshal(todo)
+	var patchTodo PatchTodo
+	err = decodeBody(body, &patchTodo)
 	if err != nil {
-		return serverError(err)
+		return decodeError(ctx, err, http.StatusUnprocessableEntity)
 	}
-	log.Printf("Successfully deleted todo item %+v", todo)
 
-	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-		},
-		Body: string(json),
-	}, nil
+	if field := nullField(body, "task", "status"); field != "" {
+		logger.InfoContext(ctx, "Patch removes a field", slog.String("field", field), slog.String("error_category", categoryValidation))
+		return clientErrorMessage(http.StatusBadRequest, fmt.Sprintf("%s can't be null", field))
+	}
+
+	if patchTodo.Task == nil && patchTodo.Status == nil {
+		return clientErrorMessage(http.StatusBadRequest, "task or status is required")
+	}
+
+	if patchTodo.Task != nil {
+		task := strings.TrimSpace(*patchTodo.Task)
+		if task == "" {
+			return validationError(ctx, []FieldError{{Field: "task", Rule: "required", Message: "task is required"}})
+		}
+		patchTodo.Task = &task
+	}
+
+	err = validate.Struct(&patchTodo)
+	if err != nil {
+		logger.InfoContext(ctx, "Invalid body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+		return validationError(ctx, fieldErrors(err, nil))
+	}
+	logger.InfoContext(ctx, "Received PATCH request", slog.String("id", id), slog.Any("item", patchTodo))
+
+	version, err := expectedVersion(req, patchTodo.Version)
+	if err != nil {
+		return versionError(ctx, err)
+	}
+
+	res, err := h.store.Patch(ctx, id, patchTodo, version)
+	if errors.Is(err, ErrVersionConflict) {
+		logger.InfoContext(ctx, "Todo was modified concurrently", slog.String("id", id), slog.Int("version", version))
+		return clientError(http.StatusConflict)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return clientError(http.StatusNotFound)
+	}
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	logger.InfoContext(ctx, "Patched todo", slog.Any("todo", res))
+	h.recordHistory(ctx, patchAction(patchTodo), *res)
+
+	return dataResponse(ctx, http.StatusOK, res)
 }
 
-func processPut(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	id, ok := req.PathParameters["id"]
-	if !ok {
-		return clientError(http.StatusBadRequest)
+// nullField returns the first of fields that the JSON object body sets to
+// null, or "" when there is none. Decoding into pointers can't tell null from
+// a missing field.
+func nullField(body []byte, fields ...string) string {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) != nil {
+		return ""
 	}
 
-	var updateTodo UpdateTodo
+	for _, field := range fields {
+		if value, ok := raw[field]; ok && string(value) == "null" {
+			return field
+		}
+	}
 
-	path := req.Path
+	return ""
+}
 
+// patchAction is the history action a patch is recorded under: the one of
+// the single-field update it amounts to, or actionPatch for both fields.
+func patchAction(patchTodo PatchTodo) string {
 	switch {
-	case strings.HasPrefix(path, "/api/task/"):
-		updateTodo = UpdateTodo{Status: true}
-	case strings.HasPrefix(path, "/api/undoTask/"):
-		log.Printf("here")
-		updateTodo = UpdateTodo{Status: false}
+	case patchTodo.Task != nil && patchTodo.Status != nil:
+		return actionPatch
+	case patchTodo.Task != nil:
+		return actionUpdateTask
+	default:
+		return actionStatus
 	}
+}
 
-	res, err := updateItem(ctx, id, updateTodo)
+// recordHistory appends the state a mutation left todo in to its history.
+// It is best effort: the mutation has already been made, so a failure is only
+// logged.
+func (h *handler) recordHistory(ctx context.Context, action string, todo Todo) {
+	err := h.store.AppendHistory(ctx, newHistoryEntry(action, todo))
 	if err != nil {
-		return serverError(err)
+		logger.ErrorContext(ctx, "Can't record todo history",
+			slog.String("id", todo.Id),
+			slog.String("action", action),
+			slog.String("error", err.Error()),
+			slog.String("error_category", categoryDatastore),
+		)
 	}
+}
 
-	if res == nil {
-		return clientError(http.StatusNotFound)
+// processHistory lists the changes made to a todo, oldest first. History
+// outlives hard deletes, so it only answers 404 for ids that never had any.
+func (h *handler) processHistory(ctx context.Context, req request, id string) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Received history request", slog.String("id", id))
+
+	entries, err := h.store.History(ctx, id)
+	if err != nil {
+		return serverError(ctx, err)
+	}
+
+	if len(entries) == 0 {
+		// Todos created before history was recorded have none.
+		_, err := h.store.Get(ctx, id, GetOptions{Fields: []string{"id"}})
+		if errors.Is(err, ErrNotFound) {
+			return clientError(http.StatusNotFound)
+		}
+		if err != nil {
+			return serverError(ctx, err)
+		}
+	}
+	logger.InfoContext(ctx, "Successfully read todo history", slog.String("id", id), slog.Int("count", len(entries)))
+
+	return dataResponse(ctx, http.StatusOK, entries)
+}
+
+// fieldErrors lists every field that failed validation, tagged with the
+// position of the item in a batch request when index is set.
+func fieldErrors(err error, index *int) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return []FieldError{{Index: index, Rule: "invalid", Message: "invalid body"}}
+	}
+
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Index:   index,
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: validationMessage(fe),
+		})
+	}
+
+	return fields
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("%s must have at most %s items", fe.Field(), fe.Param())
+		}
+
+		return fmt.Sprintf("%s must be at most %s characters", fe.Field(), fe.Param())
+	case "future":
+		return fmt.Sprintf("%s must be in the future", fe.Field())
+	case "uuid":
+		return fmt.Sprintf("%s must be a UUID", fe.Field())
+	default:
+		return fmt.Sprintf("%s is invalid", fe.Field())
+	}
+}
+
+func validationError(ctx context.Context, fields []FieldError) (events.APIGatewayProxyResponse, error) {
+	return invalidFields(http.StatusBadRequest, fields)
+}
+
+// schemaError answers a body that violates its schema in bodySchemas.
+func schemaError(ctx context.Context, fields []FieldError) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Body violates schema", slog.Any("fields", fields), slog.String("error_category", categoryValidation))
+	return validationError(ctx, fields)
+}
+
+// expectedVersion reads the todo version a client is updating from the
+// If-Match header, e.g. `"3"`, falling back to the version field of the body.
+func expectedVersion(req request, bodyVersion *int) (int, error) {
+	if match := requestHeader(req, "If-Match"); match != "" {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil {
+			return 0, fmt.Errorf("invalid If-Match header %q", match)
+		}
+
+		return version, nil
+	}
+
+	if bodyVersion != nil {
+		return *bodyVersion, nil
+	}
+
+	return 0, errMissingVersion
+}
+
+func versionError(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Can't read expected version", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+	if errors.Is(err, errMissingVersion) {
+		return clientError(http.StatusPreconditionRequired)
+	}
+
+	return clientError(http.StatusBadRequest)
+}
+
+// requestBody returns the request payload, decoding it first when API Gateway
+// delivered it base64 encoded, and enforces maxBodyBytes and jsonBody.
+func requestBody(req request) ([]byte, error) {
+	if req.Body != "" && !jsonBody(req) {
+		return nil, errUnsupportedMediaType
+	}
+
+	if !req.IsBase64Encoded {
+		if len(req.Body) > maxBodyBytes {
+			return nil, errBodyTooLarge
+		}
+
+		return []byte(req.Body), nil
+	}
+
+	if base64.StdEncoding.DecodedLen(len(req.Body)) > maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+
+	return base64.StdEncoding.DecodeString(req.Body)
+}
+
+// jsonBody reports whether the Content-Type of req is JSON, counting JSON
+// Merge Patch for PATCH. A missing Content-Type is accepted since older
+// clients don't send one.
+func jsonBody(req request) bool {
+	header := requestHeader(req, "Content-Type")
+	if header == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+
+	switch mediaType {
+	case "application/json":
+		return true
+	case "application/merge-patch+json":
+		return req.HTTPMethod == "PATCH"
+	default:
+		return false
+	}
+}
+
+// decodeBody unmarshals body into v like json.Unmarshal, except that fields v
+// doesn't declare are rejected rather than ignored, so client typos surface.
+func decodeBody(body []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		// The decoder has no typed error for unknown fields.
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("%w %s", errUnknownField, field)
+		}
+
+		return err
+	}
+
+	// Like json.Unmarshal, accept nothing but whitespace after the value.
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+
+	return nil
+}
+
+// decodeError answers a body decodeBody rejected. Unknown fields are named in
+// a 400 and values of the wrong type in a 422. Anything else malformed gets
+// status.
+func decodeError(ctx context.Context, err error, status int) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Can't unmarshal body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+	if errors.Is(err, errUnknownField) {
+		return clientErrorMessage(http.StatusBadRequest, err.Error())
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return invalidFields(http.StatusUnprocessableEntity, []FieldError{typeFieldError(typeErr)})
+	}
+
+	return clientError(status)
+}
+
+// typeFieldError describes a value of the wrong JSON type. Fields of batch
+// items come as e.g. "1.task", which is split into the index and the field.
+func typeFieldError(err *json.UnmarshalTypeError) FieldError {
+	fe := FieldError{Field: err.Field, Rule: "type"}
+	if index, field, ok := strings.Cut(err.Field, "."); ok {
+		if i, convErr := strconv.Atoi(index); convErr == nil {
+			fe.Index, fe.Field = &i, field
+		}
+	}
+
+	kind := err.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = err.Type.Elem().Kind()
+	}
+
+	var want string
+	switch kind {
+	case reflect.Bool:
+		want = "a boolean"
+	case reflect.String:
+		want = "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		want = "an integer"
+	case reflect.Slice, reflect.Array:
+		want = "an array"
+	case reflect.Struct, reflect.Map:
+		want = "an object"
+	default:
+		want = "a " + err.Type.String()
+	}
+	if err.Type == reflect.TypeOf(flexBool(false)) {
+		want = `true, false, "true", "false", 0 or 1`
+	}
+	fe.Message = fmt.Sprintf("field %s must be %s", fe.Field, want)
+
+	return fe
+}
+
+func bodyError(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	logger.InfoContext(ctx, "Can't read body", slog.String("error", err.Error()), slog.String("error_category", categoryValidation))
+	if errors.Is(err, errBodyTooLarge) {
+		return clientError(http.StatusRequestEntityTooLarge)
+	}
+
+	if errors.Is(err, errUnsupportedMediaType) {
+		return clientErrorMessage(http.StatusUnsupportedMediaType, err.Error())
+	}
+
+	if errors.Is(err, errEmptyBody) {
+		return clientErrorMessage(http.StatusBadRequest, err.Error())
 	}
 
-	log.Printf("Updated todo: %+v", res)
+	return clientError(http.StatusBadRequest)
+}
+
+// dataResponse answers with data wrapped in a DataBody.
+func dataResponse(ctx context.Context, status int, data any) (events.APIGatewayProxyResponse, error) {
+	return jsonResponse(ctx, status, DataBody{Data: data, APIVersion: apiVersion})
+}
 
-	json, err := json.Marshal(res)
+// jsonResponse marshals body as the JSON payload of a response with the given
+// status. CORS headers are added by router.
+func jsonResponse(ctx context.Context, status int, body any) (events.APIGatewayProxyResponse, error) {
+	json, err := json.Marshal(body)
 	if err != nil {
-		return serverError(err)
+		return serverError(ctx, &categorizedError{category: categorySerialization, err: err})
 	}
 
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusOK,
-		Body:       string(json),
+		StatusCode: status,
 		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-			"Location":                     fmt.Sprintf("/todo/%s", res.Id),
+			"Content-Type": "application/json",
 		},
+		Body: string(json),
 	}, nil
 }
 
 func clientError(status int) (events.APIGatewayProxyResponse, error) {
+	return clientErrorMessage(status, http.StatusText(status))
+}
+
+func clientErrorMessage(status int, message string) (events.APIGatewayProxyResponse, error) {
+	return errorResponse(status, ErrorDetail{
+		Code:    status,
+		Message: message,
+	})
+}
+
+// invalidFields answers a body that failed validation, naming every offending
+// field.
+func invalidFields(status int, fields []FieldError) (events.APIGatewayProxyResponse, error) {
+	return errorResponse(status, ErrorDetail{
+		Code:    status,
+		Message: "validation failed",
+		Fields:  fields,
+	})
+}
+
+// errorResponse answers with detail wrapped in an ErrorBody. It doesn't go
+// through jsonResponse, which reports marshaling errors by calling here.
+func errorResponse(status int, detail ErrorDetail) (events.APIGatewayProxyResponse, error) {
+	// An ErrorDetail holds nothing but strings and ints.
+	body, _ := json.Marshal(ErrorBody{
+		Error:      detail,
+		APIVersion: apiVersion,
+	})
 
 	return events.APIGatewayProxyResponse{
-		Body:       http.StatusText(status),
+		Body:       string(body),
 		StatusCode: status,
 		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
+			"Content-Type": "application/json",
 		},
 	}, nil
 }
 
-func serverError(err error) (events.APIGatewayProxyResponse, error) {
-	log.Println(err.Error())
+// Error categories are logged as error_category, so that metric filters can
+// alert on e.g. datastore errors alone.
+const (
+	categorySerialization = "serialization"
+	categoryDatastore     = "datastore"
+	categoryValidation    = "validation"
+	categoryPanic         = "panic"
+	categoryChaos         = "chaos"
+	categoryAuth          = "auth"
+)
 
-	return events.APIGatewayProxyResponse{
-		Body:       http.StatusText(http.StatusInternalServerError),
-		StatusCode: http.StatusInternalServerError,
-		Headers: map[string]string{
-			"Access-Control-Allow-Headers": "Content-Type",
-			"Access-Control-Allow-Origin":  "*",
-		},
-	}, nil
+// categorizedError tags err with the category serverError logs it under.
+type categorizedError struct {
+	category string
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() error {
+	return e.err
+}
+
+// errorCategory returns the category err was tagged with. Untagged errors
+// come from the store, which every other serverError call reports.
+func errorCategory(err error) string {
+	var categorized *categorizedError
+	if errors.As(err, &categorized) {
+		return categorized.category
+	}
+
+	return categoryDatastore
+}
+
+// defaultRetryAfter is how long clients are asked to wait before retrying a
+// throttled request or an unready API.
+const defaultRetryAfter = 2 * time.Second
+
+// throttleError answers 429 with a Retry-After header of retryAfter rounded
+// up to whole seconds.
+func throttleError(retryAfter time.Duration) (events.APIGatewayProxyResponse, error) {
+	res, err := clientErrorMessage(http.StatusTooManyRequests, "too many requests, retry later")
+	return withRetryAfter(res, retryAfter), err
+}
+
+func withRetryAfter(res events.APIGatewayProxyResponse, retryAfter time.Duration) events.APIGatewayProxyResponse {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	res.Headers = mergeHeaders(res.Headers, map[string]string{
+		"Retry-After": strconv.Itoa(seconds),
+	})
+
+	return res
+}
+
+func serverError(ctx context.Context, err error) (events.APIGatewayProxyResponse, error) {
+	category := slog.String("error_category", errorCategory(err))
+
+	if errors.Is(err, ErrThrottled) {
+		logger.WarnContext(ctx, "Storage is throttling requests", slog.String("error", err.Error()), category)
+		return throttleError(defaultRetryAfter)
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		logger.WarnContext(ctx, "Failing fast while storage is unavailable", slog.String("error", err.Error()), category)
+		res, err := clientErrorMessage(http.StatusServiceUnavailable, "storage unavailable, retry later")
+		return withRetryAfter(res, breakerCooldown), err
+	}
+
+	status := http.StatusInternalServerError
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+		logger.ErrorContext(ctx, "Storage call timed out", slog.String("error", err.Error()), category)
+	} else {
+		logger.ErrorContext(ctx, "Internal server error", slog.String("error", err.Error()), category)
+	}
+
+	return errorResponse(status, ErrorDetail{
+		Code:      status,
+		Message:   http.StatusText(status),
+		RequestId: requestIDFrom(ctx),
+	})
 }
@@ -0,0 +1,796 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is the TodoStore of handler tests. It records which methods the
+// handler called and passes them on to next, unless intercept returns an
+// error, which the method then returns instead.
+type fakeStore struct {
+	next TodoStore
+	// intercept, if set, runs before each method with the method's name.
+	intercept func(ctx context.Context, method string) error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{next: newMemoryStore()}
+}
+
+func (f *fakeStore) call(ctx context.Context, method string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, method)
+	f.mu.Unlock()
+
+	if f.intercept == nil {
+		return nil
+	}
+
+	return f.intercept(ctx, method)
+}
+
+// called reports whether the handler called method.
+func (f *fakeStore) called(method string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Contains(f.calls, method)
+}
+
+func (f *fakeStore) Ping(ctx context.Context) error {
+	if err := f.call(ctx, "Ping"); err != nil {
+		return err
+	}
+
+	return f.next.Ping(ctx)
+}
+
+func (f *fakeStore) Get(ctx context.Context, id string, opts GetOptions) (*Todo, error) {
+	if err := f.call(ctx, "Get"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Get(ctx, id, opts)
+}
+
+func (f *fakeStore) List(ctx context.Context, opts ListOptions) ([]Todo, string, error) {
+	if err := f.call(ctx, "List"); err != nil {
+		return nil, "", err
+	}
+
+	return f.next.List(ctx, opts)
+}
+
+func (f *fakeStore) Count(ctx context.Context) (int, int, error) {
+	if err := f.call(ctx, "Count"); err != nil {
+		return 0, 0, err
+	}
+
+	return f.next.Count(ctx)
+}
+
+func (f *fakeStore) Search(ctx context.Context, opts SearchOptions) ([]Todo, string, error) {
+	if err := f.call(ctx, "Search"); err != nil {
+		return nil, "", err
+	}
+
+	return f.next.Search(ctx, opts)
+}
+
+func (f *fakeStore) Insert(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
+	if err := f.call(ctx, "Insert"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Insert(ctx, createTodo)
+}
+
+func (f *fakeStore) InsertIdempotent(ctx context.Context, createTodo CreateTodo, key string) (*Todo, bool, error) {
+	if err := f.call(ctx, "InsertIdempotent"); err != nil {
+		return nil, false, err
+	}
+
+	return f.next.InsertIdempotent(ctx, createTodo, key)
+}
+
+func (f *fakeStore) BatchInsert(ctx context.Context, createTodos []CreateTodo) ([]Todo, error) {
+	if err := f.call(ctx, "BatchInsert"); err != nil {
+		return nil, err
+	}
+
+	return f.next.BatchInsert(ctx, createTodos)
+}
+
+func (f *fakeStore) UpdateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (*Todo, error) {
+	if err := f.call(ctx, "UpdateStatus"); err != nil {
+		return nil, err
+	}
+
+	return f.next.UpdateStatus(ctx, id, updateTodo, version)
+}
+
+func (f *fakeStore) SetStatuses(ctx context.Context, ids []string, status bool) []StatusChange {
+	if err := f.call(ctx, "SetStatuses"); err != nil {
+		changes := make([]StatusChange, 0, len(ids))
+		for _, id := range ids {
+			changes = append(changes, StatusChange{Id: id, Err: err})
+		}
+
+		return changes
+	}
+
+	return f.next.SetStatuses(ctx, ids, status)
+}
+
+func (f *fakeStore) Toggle(ctx context.Context, id string) (*Todo, error) {
+	if err := f.call(ctx, "Toggle"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Toggle(ctx, id)
+}
+
+func (f *fakeStore) Patch(ctx context.Context, id string, patchTodo PatchTodo, version int) (*Todo, error) {
+	if err := f.call(ctx, "Patch"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Patch(ctx, id, patchTodo, version)
+}
+
+func (f *fakeStore) Replace(ctx context.Context, id string, replaceTodo ReplaceTodo, version int) (*Todo, error) {
+	if err := f.call(ctx, "Replace"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Replace(ctx, id, replaceTodo, version)
+}
+
+func (f *fakeStore) Delete(ctx context.Context, id string) (*Todo, error) {
+	if err := f.call(ctx, "Delete"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Delete(ctx, id)
+}
+
+func (f *fakeStore) SoftDelete(ctx context.Context, id string) (*Todo, error) {
+	if err := f.call(ctx, "SoftDelete"); err != nil {
+		return nil, err
+	}
+
+	return f.next.SoftDelete(ctx, id)
+}
+
+func (f *fakeStore) Restore(ctx context.Context, id string) (*Todo, error) {
+	if err := f.call(ctx, "Restore"); err != nil {
+		return nil, err
+	}
+
+	return f.next.Restore(ctx, id)
+}
+
+func (f *fakeStore) SetPosition(ctx context.Context, id string, position float64) (*Todo, error) {
+	if err := f.call(ctx, "SetPosition"); err != nil {
+		return nil, err
+	}
+
+	return f.next.SetPosition(ctx, id, position)
+}
+
+func (f *fakeStore) DeleteAll(ctx context.Context) (int, error) {
+	if err := f.call(ctx, "DeleteAll"); err != nil {
+		return 0, err
+	}
+
+	return f.next.DeleteAll(ctx)
+}
+
+func (f *fakeStore) AppendHistory(ctx context.Context, entry HistoryEntry) error {
+	if err := f.call(ctx, "AppendHistory"); err != nil {
+		return err
+	}
+
+	return f.next.AppendHistory(ctx, entry)
+}
+
+func (f *fakeStore) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	if err := f.call(ctx, "History"); err != nil {
+		return nil, err
+	}
+
+	return f.next.History(ctx, id)
+}
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr bool
+	}{
+		{name: "valid", id: "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"},
+		{name: "empty", id: "", wantErr: true},
+		{name: "too long", id: "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10" + strings.Repeat("0", 64), wantErr: true},
+		{name: "not a uuid", id: strings.Repeat("x", 36), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateID(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateID(%q) = %v, want error %v", tt.id, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMalformedIDIsRejected(t *testing.T) {
+	h := newHandler(newMemoryStore())
+	tooLong := strings.Repeat("a", 100)
+
+	for _, req := range []request{
+		{HTTPMethod: "GET", Path: "/api/task/" + tooLong, PathParameters: map[string]string{"id": tooLong}},
+		{HTTPMethod: "PUT", Path: "/api/task/" + tooLong, PathParameters: map[string]string{"id": tooLong}},
+		{HTTPMethod: "DELETE", Path: "/api/deleteTask/" + tooLong, PathParameters: map[string]string{"id": tooLong}},
+	} {
+		res := serve(t, h, req)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s %s = %d, want %d", req.HTTPMethod, req.Path[:20], res.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLegacyPutWithoutIfMatch(t *testing.T) {
+	store := newMemoryStore()
+	h := newHandler(store)
+	todo, err := store.Insert(context.Background(), CreateTodo{Task: "write tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path       string
+		wantStatus bool
+	}{
+		{path: "/api/task/" + todo.Id, wantStatus: true},
+		{path: "/api/undoTask/" + todo.Id, wantStatus: false},
+	} {
+		res := serve(t, h, request{HTTPMethod: "PUT", Path: tt.path, PathParameters: map[string]string{"id": todo.Id}})
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("PUT %s = %d, want %d: %s", tt.path, res.StatusCode, http.StatusOK, res.Body)
+		}
+
+		var got Todo
+		decodeData(t, res, &got)
+		if got.Status != tt.wantStatus {
+			t.Errorf("PUT %s: status = %v, want %v", tt.path, got.Status, tt.wantStatus)
+		}
+	}
+
+	res := serve(t, h, request{
+		HTTPMethod:     "PUT",
+		Path:           "/api/task/" + todo.Id,
+		PathParameters: map[string]string{"id": todo.Id},
+		Headers:        map[string]string{"If-Match": `"1"`},
+	})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("PUT with a stale If-Match = %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestHandlerUsesStore(t *testing.T) {
+	store := newFakeStore()
+	h := newHandler(store)
+
+	res := serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"write tests"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/task = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+	}
+	var created Todo
+	decodeData(t, res, &created)
+	if !store.called("Insert") {
+		t.Errorf("POST /api/task didn't call Insert, calls: %v", store.calls)
+	}
+
+	path := "/api/task/" + created.Id
+	res = serve(t, h, request{HTTPMethod: "GET", Path: path, PathParameters: map[string]string{"id": created.Id}})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d, want %d: %s", path, res.StatusCode, http.StatusOK, res.Body)
+	}
+	var got Todo
+	decodeData(t, res, &got)
+	if got.Task != "write tests" {
+		t.Errorf("GET %s: task = %q, want %q", path, got.Task, "write tests")
+	}
+
+	store.intercept = func(context.Context, string) error { return errors.New("store is down") }
+	res = serve(t, h, request{HTTPMethod: "GET", Path: path, PathParameters: map[string]string{"id": created.Id}})
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GET %s with a failing store = %d, want %d", path, res.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		method string
+		// path is the request path, with {id} standing for an existing todo.
+		path       string
+		body       string
+		wantStatus int
+		// wantCall is the store method serving the request, if any.
+		wantCall  string
+		wantAllow string
+	}{
+		{method: "GET", path: "/api/task", wantStatus: http.StatusOK, wantCall: "List"},
+		{method: "POST", path: "/api/task", body: `{"task":"write tests"}`, wantStatus: http.StatusCreated, wantCall: "Insert"},
+		{method: "GET", path: "/api/task/count", wantStatus: http.StatusOK, wantCall: "Count"},
+		{method: "GET", path: "/api/task/{id}", wantStatus: http.StatusOK, wantCall: "Get"},
+		{method: "PUT", path: "/api/task/{id}", wantStatus: http.StatusOK, wantCall: "SetStatuses"},
+		{method: "PUT", path: "/api/undoTask/{id}", wantStatus: http.StatusOK, wantCall: "SetStatuses"},
+		{method: "POST", path: "/api/task/{id}/toggle", wantStatus: http.StatusOK, wantCall: "Toggle"},
+		{method: "GET", path: "/api/task/{id}/history", wantStatus: http.StatusOK, wantCall: "History"},
+		{method: "DELETE", path: "/api/deleteTask/{id}", wantStatus: http.StatusOK, wantCall: "SoftDelete"},
+		{method: "OPTIONS", path: "/api/task", wantStatus: http.StatusOK},
+		{method: "GET", path: "/api/health", wantStatus: http.StatusOK},
+
+		{method: "PUT", path: "/api/task", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, POST, DELETE, OPTIONS"},
+		{method: "DELETE", path: "/api/task/{id}", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, PUT, PATCH, OPTIONS"},
+		{method: "POST", path: "/api/undoTask/{id}", wantStatus: http.StatusMethodNotAllowed, wantAllow: "PUT, OPTIONS"},
+		{method: "GET", path: "/api/deleteTask/{id}", wantStatus: http.StatusMethodNotAllowed, wantAllow: "DELETE, OPTIONS"},
+
+		// Paths of other resources hold no id for the route.
+		{method: "GET", path: "/api/task/{id}/toggle", wantStatus: http.StatusBadRequest},
+		{method: "PUT", path: "/api/task/{id}/toggle", wantStatus: http.StatusBadRequest},
+		{method: "PUT", path: "/api/undoTask/{id}/status", wantStatus: http.StatusBadRequest},
+
+		{method: "GET", path: "/", wantStatus: http.StatusNotFound},
+		{method: "GET", path: "/api/tasks", wantStatus: http.StatusNotFound},
+		{method: "POST", path: "/api/unknown/{id}", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			store := newFakeStore()
+			todo, err := store.next.Insert(context.Background(), CreateTodo{Task: "write tests"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := newHandler(store)
+
+			res := serve(t, h, request{
+				HTTPMethod: tt.method,
+				Path:       strings.ReplaceAll(tt.path, "{id}", todo.Id),
+				Body:       tt.body,
+			})
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, tt.wantStatus, res.Body)
+			}
+			if tt.wantCall != "" && !store.called(tt.wantCall) {
+				t.Errorf("store calls = %v, want %s", store.calls, tt.wantCall)
+			}
+			if tt.wantCall == "" && tt.wantStatus != http.StatusOK && len(store.calls) > 0 {
+				t.Errorf("store calls = %v, want none", store.calls)
+			}
+			if got := res.Headers["Allow"]; got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestMissingIDIsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		req  request
+	}{
+		{name: "no path parameter", req: request{HTTPMethod: "PUT", Path: "/api/task/"}},
+		{name: "no undo path parameter", req: request{HTTPMethod: "PUT", Path: "/api/undoTask/"}},
+		{name: "no delete path parameter", req: request{HTTPMethod: "DELETE", Path: "/api/deleteTask/"}},
+		{name: "empty path parameter", req: request{HTTPMethod: "GET", Path: "/api/task/", PathParameters: map[string]string{"id": ""}}},
+		{name: "empty undo path parameter", req: request{HTTPMethod: "PUT", Path: "/api/undoTask/", PathParameters: map[string]string{"id": ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			res := serve(t, newHandler(store), tt.req)
+			if res.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s %s = %d, want %d: %s", tt.req.HTTPMethod, tt.req.Path, res.StatusCode, http.StatusBadRequest, res.Body)
+			}
+			if len(store.calls) > 0 {
+				t.Errorf("store calls = %v, want none", store.calls)
+			}
+		})
+	}
+}
+
+func TestPostBodyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantMessage string
+		wantField   string
+	}{
+		{name: "empty", body: "", wantStatus: http.StatusBadRequest, wantMessage: "request body is required"},
+		{name: "blank", body: " \n", wantStatus: http.StatusBadRequest, wantMessage: "request body is required"},
+		{name: "malformed", body: `{"task":`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "invalid", body: `{"task":""}`, wantStatus: http.StatusBadRequest, wantField: "task"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			res := serve(t, newHandler(store), request{HTTPMethod: "POST", Path: "/api/task", Body: tt.body})
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, tt.wantStatus, res.Body)
+			}
+
+			detail := decodeErrorBody(t, res)
+			if tt.wantMessage != "" && detail.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", detail.Message, tt.wantMessage)
+			}
+			if tt.wantField != "" && (len(detail.Fields) == 0 || detail.Fields[0].Field != tt.wantField) {
+				t.Errorf("fields = %+v, want one for %s", detail.Fields, tt.wantField)
+			}
+			if tt.wantField == "" && len(detail.Fields) > 0 {
+				t.Errorf("fields = %+v, want none", detail.Fields)
+			}
+			if store.called("Insert") {
+				t.Error("the invalid todo was inserted")
+			}
+		})
+	}
+}
+
+func TestPanicIsServerError(t *testing.T) {
+	setFor(t, &allowedOrigins, parseAllowedOrigins("https://app.example.com"))
+
+	store := newFakeStore()
+	store.intercept = func(context.Context, string) error {
+		var todos map[string]*Todo
+		todos["x"].Task = "nil map"
+		return nil
+	}
+
+	res := serve(t, newHandler(store), request{
+		HTTPMethod: "GET",
+		Path:       "/api/task",
+		Headers:    map[string]string{"Origin": "https://app.example.com"},
+	})
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusInternalServerError, res.Body)
+	}
+	if detail := decodeErrorBody(t, res); detail.Code != http.StatusInternalServerError {
+		t.Errorf("error code = %d, want %d", detail.Code, http.StatusInternalServerError)
+	}
+	if got := res.Headers["Access-Control-Allow-Origin"]; got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+}
+
+func TestPostLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		stage   string
+		want    string
+	}{
+		{
+			name:    "execute-api host",
+			headers: map[string]string{"Host": "abc123.execute-api.ap-northeast-1.amazonaws.com"},
+			stage:   "Prod",
+			want:    "https://abc123.execute-api.ap-northeast-1.amazonaws.com/Prod/api/task/",
+		},
+		{
+			name:    "custom domain",
+			headers: map[string]string{"host": "todo.example.com", "X-Forwarded-Proto": "http"},
+			stage:   "Prod",
+			want:    "http://todo.example.com/api/task/",
+		},
+		{name: "no host", stage: "Prod", want: "/api/task/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request{HTTPMethod: "POST", Path: "/api/task", Headers: tt.headers, Body: `{"task":"write tests"}`}
+			req.Stage = tt.stage
+
+			res := serve(t, newHandler(newMemoryStore()), req)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+			}
+
+			var todo Todo
+			decodeData(t, res, &todo)
+			if got := res.Headers["Location"]; got != tt.want+todo.Id {
+				t.Errorf("Location = %q, want %q", got, tt.want+todo.Id)
+			}
+		})
+	}
+}
+
+func TestStoreDeadlineIsGatewayTimeout(t *testing.T) {
+	store := newFakeStore()
+	store.intercept = func(ctx context.Context, method string) error {
+		// Block like a hung DynamoDB call until its operation deadline.
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	res := serve(t, newHandler(store), request{HTTPMethod: "GET", Path: "/api/task"})
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusGatewayTimeout, res.Body)
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	setFor(t, &maxPageSize, 100)
+
+	tests := []struct {
+		name    string
+		query   map[string]string
+		want    int
+		wantErr bool
+	}{
+		{name: "absent", want: defaultPageSize},
+		{name: "zero", query: map[string]string{"limit": "0"}, want: defaultPageSize},
+		{name: "set", query: map[string]string{"limit": "5"}, want: 5},
+		{name: "maximum", query: map[string]string{"limit": "100"}, want: 100},
+		{name: "oversized", query: map[string]string{"limit": "100000"}, want: 100},
+		{name: "negative", query: map[string]string{"limit": "-1"}, wantErr: true},
+		{name: "not a number", query: map[string]string{"limit": "ten"}, wantErr: true},
+		{name: "empty", query: map[string]string{"limit": ""}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pageLimit(request{QueryStringParameters: tt.query})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pageLimit = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pageLimit = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListLimit(t *testing.T) {
+	setFor(t, &maxPageSize, 3)
+
+	store := newMemoryStore()
+	for range 5 {
+		if _, err := store.Insert(context.Background(), CreateTodo{Task: "write tests"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newHandler(store)
+
+	res := serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: map[string]string{"limit": "100000"}})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("limit=100000: status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+	var page struct {
+		Items []Todo `json:"items"`
+	}
+	decodeData(t, res, &page)
+	if len(page.Items) != 3 {
+		t.Errorf("limit=100000 returned %d todos, want maxPageSize", len(page.Items))
+	}
+
+	res = serve(t, h, request{HTTPMethod: "GET", Path: "/api/task", QueryStringParameters: map[string]string{"limit": "-1"}})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("limit=-1: status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestWrongBodyTypesAreUnprocessable(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		// path is the request path, with {id} standing for an existing todo.
+		path      string
+		body      string
+		wantField string
+		wantType  string
+	}{
+		{name: "create task number", method: "POST", path: "/api/task", body: `{"task":123}`, wantField: "task", wantType: "a string"},
+		{name: "create task list", method: "POST", path: "/api/task", body: `{"task":["a"]}`, wantField: "task", wantType: "a string"},
+		{name: "create status word", method: "POST", path: "/api/task", body: `{"task":"x","status":"yes"}`, wantField: "status", wantType: "a boolean"},
+		{name: "replace task number", method: "PUT", path: "/api/task/{id}", body: `{"task":1,"status":true}`, wantField: "task", wantType: "a string"},
+		{name: "replace status word", method: "PUT", path: "/api/task/{id}", body: `{"task":"x","status":"yes"}`, wantField: "status", wantType: "a boolean"},
+		{name: "update status word", method: "PUT", path: "/api/task/{id}/status", body: `{"status":"yes"}`, wantField: "status", wantType: `true, false, "true", "false", 0 or 1`},
+		{name: "update status object", method: "PUT", path: "/api/task/{id}/status", body: `{"status":{}}`, wantField: "status", wantType: `true, false, "true", "false", 0 or 1`},
+		{name: "update version string", method: "PUT", path: "/api/task/{id}/status", body: `{"status":true,"version":"1"}`, wantField: "version", wantType: "an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			todo, err := store.Insert(context.Background(), CreateTodo{Task: "write tests"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := serve(t, newHandler(store), request{
+				HTTPMethod: tt.method,
+				Path:       strings.ReplaceAll(tt.path, "{id}", todo.Id),
+				Headers:    map[string]string{"If-Match": `"1"`},
+				Body:       tt.body,
+			})
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusUnprocessableEntity, res.Body)
+			}
+
+			detail := decodeErrorBody(t, res)
+			if len(detail.Fields) != 1 || detail.Fields[0].Field != tt.wantField || detail.Fields[0].Rule != "type" {
+				t.Fatalf("fields = %+v, want a type error of %s", detail.Fields, tt.wantField)
+			}
+			if want := "must be " + tt.wantType; !strings.Contains(detail.Fields[0].Message, want) {
+				t.Errorf("message = %q, want it to say %q", detail.Fields[0].Message, want)
+			}
+		})
+	}
+}
+
+func TestStatusAcceptsFlexibleBooleans(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{body: `{"status":true}`, want: true},
+		{body: `{"status":false}`, want: false},
+		{body: `{"status":"true"}`, want: true},
+		{body: `{"status":"false"}`, want: false},
+		{body: `{"status":1}`, want: true},
+		{body: `{"status":0}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			var update UpdateTodo
+			if err := json.Unmarshal([]byte(tt.body), &update); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			if update.Status != tt.want {
+				t.Errorf("decoded status = %t, want %t", update.Status, tt.want)
+			}
+
+			store := newMemoryStore()
+			todo, err := store.Insert(context.Background(), CreateTodo{Task: "write tests", Status: !tt.want})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := serve(t, newHandler(store), request{
+				HTTPMethod: "PUT",
+				Path:       "/api/task/" + todo.Id + "/status",
+				Headers:    map[string]string{"If-Match": `"1"`},
+				Body:       tt.body,
+			})
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+			}
+			var updated Todo
+			decodeData(t, res, &updated)
+			if updated.Status != tt.want {
+				t.Errorf("updated status = %t, want %t", updated.Status, tt.want)
+			}
+		})
+	}
+
+	for _, body := range []string{`{"status":2}`, `{"status":"yes"}`, `{"status":"1"}`, `{"status":1.0}`} {
+		var update UpdateTodo
+		var typeErr *json.UnmarshalTypeError
+		if err := json.Unmarshal([]byte(body), &update); !errors.As(err, &typeErr) || typeErr.Field != "status" {
+			t.Errorf("decoding %s: err = %v, want a type error of status", body, err)
+		}
+	}
+}
+
+func TestClientIDs(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	body := `{"id":"` + id + `","task":"write tests"}`
+
+	t.Run("not allowed", func(t *testing.T) {
+		setFor(t, &allowClientIDs, false)
+
+		res := serve(t, newHandler(newMemoryStore()), request{HTTPMethod: "POST", Path: "/api/task", Body: body})
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusBadRequest, res.Body)
+		}
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		setFor(t, &allowClientIDs, true)
+		h := newHandler(newMemoryStore())
+
+		res := serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: body})
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+		}
+		var todo Todo
+		decodeData(t, res, &todo)
+		if todo.Id != id {
+			t.Errorf("id = %s, want the client's %s", todo.Id, id)
+		}
+
+		res = serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: body})
+		if res.StatusCode != http.StatusConflict {
+			t.Errorf("duplicate: status = %d, want %d: %s", res.StatusCode, http.StatusConflict, res.Body)
+		}
+	})
+
+	t.Run("duplicate in DynamoDB", func(t *testing.T) {
+		setFor(t, &allowClientIDs, true)
+		store, _ := newFakeDynamo(t, func(op string, input map[string]any) any {
+			return dynamoError{Type: "ConditionalCheckFailedException", Message: "The conditional request failed"}
+		})
+
+		res := serve(t, newHandler(store), request{HTTPMethod: "POST", Path: "/api/task", Body: body})
+		if res.StatusCode != http.StatusConflict {
+			t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusConflict, res.Body)
+		}
+	})
+}
+
+func TestStoreErrorsMapToStatus(t *testing.T) {
+	id := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	routes := []struct {
+		req request
+		// versioned routes are the ones that can conflict.
+		versioned bool
+	}{
+		{req: request{HTTPMethod: "GET", Path: "/api/task/" + id}},
+		{req: request{HTTPMethod: "PUT", Path: "/api/task/" + id + "/status", Headers: map[string]string{"If-Match": `"1"`}, Body: `{"status":true}`}, versioned: true},
+		{req: request{HTTPMethod: "PATCH", Path: "/api/task/" + id, Headers: map[string]string{"If-Match": `"1"`}, Body: `{"task":"write tests"}`}, versioned: true},
+		{req: request{HTTPMethod: "POST", Path: "/api/task/" + id + "/toggle"}, versioned: true},
+		{req: request{HTTPMethod: "DELETE", Path: "/api/deleteTask/" + id}},
+	}
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		versioned  bool
+	}{
+		{name: "not found", err: ErrNotFound, wantStatus: http.StatusNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("reading todo: %w", ErrNotFound), wantStatus: http.StatusNotFound},
+		{name: "version conflict", err: ErrVersionConflict, wantStatus: http.StatusConflict, versioned: true},
+		{name: "failure", err: errors.New("connection reset"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		for _, route := range routes {
+			if tt.versioned && !route.versioned {
+				continue
+			}
+
+			req := route.req
+			t.Run(tt.name+" "+req.HTTPMethod+" "+req.Path, func(t *testing.T) {
+				store := newFakeStore()
+				store.intercept = func(context.Context, string) error { return tt.err }
+
+				res := serve(t, newHandler(store), req)
+				if res.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d: %s", res.StatusCode, tt.wantStatus, res.Body)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useBodySchemas compiles doc like BODY_SCHEMA for the rest of the test.
+func useBodySchemas(t *testing.T, doc string) {
+	t.Helper()
+
+	t.Setenv("BODY_SCHEMA", doc)
+	t.Setenv("BODY_SCHEMA_FILE", "")
+	schemas, err := loadBodySchemas()
+	if err != nil {
+		t.Fatalf("loadBodySchemas: %v", err)
+	}
+	setFor(t, &bodySchemas, schemas)
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		body   string
+		// want lists a field:rule per violation, or nothing for a valid body.
+		want []string
+	}{
+		{name: "type", schema: `{"properties": {"task": {"type": "string"}}}`, body: `{"task": 1}`, want: []string{"task:type"}},
+		{name: "type valid", schema: `{"properties": {"task": {"type": "string"}}}`, body: `{"task": "x"}`},
+		{name: "integer", schema: `{"properties": {"version": {"type": "integer"}}}`, body: `{"version": 1.5}`, want: []string{"version:type"}},
+		{name: "enum", schema: `{"properties": {"task": {"enum": ["a", "b"]}}}`, body: `{"task": "c"}`, want: []string{"task:enum"}},
+		{name: "const", schema: `{"properties": {"status": {"const": false}}}`, body: `{"status": true}`, want: []string{"status:const"}},
+		{name: "required", schema: `{"required": ["task"]}`, body: `{}`, want: []string{":required"}},
+		{name: "additionalProperties", schema: `{"properties": {"task": {}}, "additionalProperties": false}`, body: `{"task": "x", "note": "y"}`, want: []string{":additionalProperties"}},
+		{name: "minLength", schema: `{"properties": {"task": {"minLength": 3}}}`, body: `{"task": "ab"}`, want: []string{"task:minLength"}},
+		{name: "maxLength", schema: `{"properties": {"task": {"maxLength": 3}}}`, body: `{"task": "abcd"}`, want: []string{"task:maxLength"}},
+		{name: "maxLength counts characters", schema: `{"properties": {"task": {"maxLength": 3}}}`, body: `{"task": "äöü"}`},
+		{name: "pattern", schema: `{"properties": {"task": {"pattern": "^[a-z ]+$"}}}`, body: `{"task": "Write"}`, want: []string{"task:pattern"}},
+		{name: "format", schema: `{"properties": {"ids": {"items": {"format": "uuid"}}}}`, body: `{"ids": ["nope"]}`, want: []string{"ids/0:format"}},
+		{name: "minItems", schema: `{"properties": {"ids": {"minItems": 2}}}`, body: `{"ids": ["a"]}`, want: []string{"ids:minItems"}},
+		{name: "maxItems", schema: `{"properties": {"ids": {"maxItems": 1}}}`, body: `{"ids": ["a", "b"]}`, want: []string{"ids:maxItems"}},
+		{name: "items", schema: `{"properties": {"ids": {"items": {"type": "string"}}}}`, body: `{"ids": ["a", 2]}`, want: []string{"ids/1:type"}},
+		{name: "minimum", schema: `{"properties": {"version": {"minimum": 1}}}`, body: `{"version": 0}`, want: []string{"version:minimum"}},
+		{name: "maximum", schema: `{"properties": {"version": {"maximum": 10}}}`, body: `{"version": 11}`, want: []string{"version:maximum"}},
+		{name: "large integers", schema: `{"properties": {"version": {"maximum": 9007199254740993}}}`, body: `{"version": 9007199254740994}`, want: []string{"version:maximum"}},
+		{
+			name:   "several",
+			schema: `{"required": ["task"], "properties": {"status": {"type": "boolean"}, "version": {"minimum": 1}}}`,
+			body:   `{"status": "x", "version": 0}`,
+			want:   []string{":required", "status:type", "version:minimum"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBodySchemas(t, `{"CreateTodo": `+tt.schema+`}`)
+
+			var got []string
+			for _, field := range schemaViolations("CreateTodo", []byte(tt.body), nil) {
+				got = append(got, field.Field+":"+field.Rule)
+				if field.Message == "" {
+					t.Errorf("%s:%s has no message", field.Field, field.Rule)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodySchemasThatDontCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{name: "not json", doc: `{"CreateTodo": `},
+		{name: "unknown body type", doc: `{"Todo": {}}`},
+		{name: "invalid keyword value", doc: `{"CreateTodo": {"maxLength": "ten"}}`},
+		{name: "invalid pattern", doc: `{"CreateTodo": {"pattern": "("}}`},
+		{name: "external ref", doc: `{"CreateTodo": {"$ref": "https://example.com/todo.json"}}`},
+		{name: "file ref", doc: `{"CreateTodo": {"$ref": "file:///etc/passwd"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BODY_SCHEMA", tt.doc)
+			t.Setenv("BODY_SCHEMA_FILE", "")
+
+			if _, err := loadBodySchemas(); err == nil {
+				t.Error("loadBodySchemas succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSchemaViolationIsBadRequest(t *testing.T) {
+	useBodySchemas(t, `{"CreateTodo": {"properties": {"task": {"maxLength": 5}}}}`)
+	h := newHandler(newMemoryStore())
+
+	res := serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"write tests"}`})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusBadRequest, res.Body)
+	}
+	detail := decodeErrorBody(t, res)
+	if len(detail.Fields) != 1 || detail.Fields[0].Field != "task" || detail.Fields[0].Rule != "maxLength" {
+		t.Errorf("fields = %+v, want a maxLength violation of task", detail.Fields)
+	}
+
+	res = serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"tests"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("valid body: status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+	}
+}
+
+func TestBatchSchemaViolationsHaveIndexes(t *testing.T) {
+	useBodySchemas(t, `{"CreateTodo": {"properties": {"task": {"maxLength": 5}}}}`)
+
+	fields := batchSchemaViolations("CreateTodo", []byte(`[{"task":"tests"},{"task":"write tests"}]`))
+	if len(fields) != 1 || fields[0].Index == nil || *fields[0].Index != 1 || fields[0].Field != "task" {
+		t.Errorf("fields = %+v, want task of item 1", fields)
+	}
+}
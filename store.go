@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TodoStore persists todos. Handlers only talk to storage through it, so it can
+// be swapped out, e.g. for a fake in tests.
+//
+// When ctx carries an authenticated subject, every method only sees the todos
+// owned by it, and todos of other owners behave as if they didn't exist.
+//
+// Methods addressing a single todo return ErrNotFound when it doesn't exist,
+// so a nil error always comes with a todo.
+type TodoStore interface {
+	Ping(ctx context.Context) error
+	Get(ctx context.Context, id string, opts GetOptions) (*Todo, error)
+	List(ctx context.Context, opts ListOptions) ([]Todo, string, error)
+	Count(ctx context.Context) (int, int, error)
+	Search(ctx context.Context, opts SearchOptions) ([]Todo, string, error)
+	Insert(ctx context.Context, createTodo CreateTodo) (*Todo, error)
+	InsertIdempotent(ctx context.Context, createTodo CreateTodo, key string) (*Todo, bool, error)
+	BatchInsert(ctx context.Context, createTodos []CreateTodo) ([]Todo, error)
+	UpdateStatus(ctx context.Context, id string, updateTodo UpdateTodo, version int) (*Todo, error)
+	SetStatuses(ctx context.Context, ids []string, status bool) []StatusChange
+	Toggle(ctx context.Context, id string) (*Todo, error)
+	Patch(ctx context.Context, id string, patchTodo PatchTodo, version int) (*Todo, error)
+	Replace(ctx context.Context, id string, replaceTodo ReplaceTodo, version int) (*Todo, error)
+	Delete(ctx context.Context, id string) (*Todo, error)
+	SoftDelete(ctx context.Context, id string) (*Todo, error)
+	Restore(ctx context.Context, id string) (*Todo, error)
+	SetPosition(ctx context.Context, id string, position float64) (*Todo, error)
+	DeleteAll(ctx context.Context) (int, error)
+	AppendHistory(ctx context.Context, entry HistoryEntry) error
+	History(ctx context.Context, id string) ([]HistoryEntry, error)
+}
+
+// ErrNotFound is returned when there is no todo with the id, or none in the
+// state the method acts on, like a soft deleted one for Restore. It is also
+// returned by InsertIdempotent when the todo created with the key has since
+// been deleted.
+var ErrNotFound = errors.New("todo not found")
+
+// ErrConflict is wrapped by the errors of writes that clash with the current
+// state of a todo, so that they can all be told apart from store failures.
+var ErrConflict = errors.New("conflict")
+
+// ErrVersionConflict is returned when a todo was modified after the client
+// read the version it is trying to update.
+var ErrVersionConflict = fmt.Errorf("%w: todo version conflict", ErrConflict)
+
+// ErrDuplicateID is returned by Insert and InsertIdempotent when a todo with
+// the client-chosen id already exists.
+var ErrDuplicateID = fmt.Errorf("%w: todo id already exists", ErrConflict)
+
+// ErrDuplicateTask is returned by Insert and InsertIdempotent when uniqueTasks
+// is set and the owner already has a todo with the same task.
+var ErrDuplicateTask = fmt.Errorf("%w: todo task already exists", ErrConflict)
+
+// ErrThrottled is returned when the backing store rejected a call for
+// exceeding its capacity, even after retrying.
+var ErrThrottled = errors.New("store throttled the request")
+
+// ErrInvalidCursor is returned by List for a cursor it didn't issue or that
+// has expired.
+var ErrInvalidCursor = errors.New("invalid or expired cursor")
+
+// ErrCircuitOpen is returned without calling the store while the circuit
+// breaker is open after repeated failures.
+var ErrCircuitOpen = errors.New("store circuit breaker is open")
+
+// StatusChange is the outcome of SetStatuses for a single todo. Err is
+// ErrNotFound when the todo doesn't exist. Unchanged is set when the todo
+// already had the status, in which case nothing was written and Todo is its
+// current state.
+type StatusChange struct {
+	Id        string
+	Todo      *Todo
+	Err       error
+	Unchanged bool
+}
+
+// timestampLayout is RFC 3339 with fixed millisecond precision, so that UTC
+// timestamps sort lexicographically.
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type Todo struct {
+	Id        string `json:"id" dynamodbav:"id"`
+	Task      string `json:"task" dynamodbav:"task"`
+	Status    bool   `json:"status" dynamodbav:"status"`
+	CreatedAt string `json:"createdAt,omitempty" dynamodbav:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+	Version   int    `json:"version" dynamodbav:"version"`
+	Deleted   bool   `json:"deleted,omitempty" dynamodbav:"deleted,omitempty"`
+	DeletedAt string `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	// Position orders the todos of a list, lowest first. Moving a todo gives
+	// it a position between its new neighbours, so no other todo changes.
+	Position float64 `json:"position,omitempty" dynamodbav:"position,omitempty"`
+	// ExpiresAt is in epoch seconds. The Todos table's TTL deletes the todo
+	// some time after it passes.
+	ExpiresAt int64 `json:"expiresAt,omitempty" dynamodbav:"expiresAt,omitempty"`
+	// TaskLower is the lowercased task, which Search matches against.
+	TaskLower string `json:"-" dynamodbav:"taskLower,omitempty"`
+	// Owner is the subject that created the todo, empty when auth is off.
+	Owner string `json:"-" dynamodbav:"owner,omitempty"`
+	// List is the list the todo is ordered in, from listOf. It partitions the
+	// position index, which is sorted by Position.
+	List string `json:"-" dynamodbav:"list,omitempty"`
+}
+
+// History actions, one per kind of mutation.
+const (
+	actionCreate     = "create"
+	actionStatus     = "status"
+	actionUpdateTask = "updateTask"
+	actionPatch      = "patch"
+	actionReplace    = "replace"
+	actionDelete     = "delete"
+	actionHardDelete = "hardDelete"
+	actionRestore    = "restore"
+	actionMove       = "move"
+)
+
+// HistoryEntry records the state a mutation left a todo in. Entries only
+// store the new status; History fills in OldStatus from the entry before.
+type HistoryEntry struct {
+	TodoId string `json:"-" dynamodbav:"todoId"`
+	// Seq orders the entries of a todo by the version they left it at, which
+	// every write but a hard delete bumps. A hard delete sorts after the
+	// entry of the version it removed.
+	Seq       string `json:"-" dynamodbav:"seq"`
+	At        string `json:"at" dynamodbav:"at"`
+	Action    string `json:"action" dynamodbav:"action"`
+	OldStatus *bool  `json:"oldStatus,omitempty" dynamodbav:"-"`
+	Status    bool   `json:"status" dynamodbav:"status"`
+	Version   int    `json:"version" dynamodbav:"version"`
+	Owner     string `json:"-" dynamodbav:"owner,omitempty"`
+}
+
+func newHistoryEntry(action string, todo Todo) HistoryEntry {
+	seq := fmt.Sprintf("%010d", todo.Version)
+	if action == actionHardDelete {
+		seq += "#" + action
+	}
+
+	return HistoryEntry{
+		TodoId:  todo.Id,
+		Seq:     seq,
+		At:      now(),
+		Action:  action,
+		Status:  todo.Status,
+		Version: todo.Version,
+		Owner:   todo.Owner,
+	}
+}
+
+// linkHistory sets the old status of every entry but the first from the one
+// before it. entries must be in order.
+func linkHistory(entries []HistoryEntry) {
+	for i := 1; i < len(entries); i++ {
+		entries[i].OldStatus = &entries[i-1].Status
+	}
+}
+
+func now() string {
+	return time.Now().UTC().Format(timestampLayout)
+}
+
+// GetOptions tunes a Get call. Fields limits the attributes read to the ones
+// listed, named as in the JSON representation; none means every attribute.
+// Consistent asks for a strongly consistent read, which sees every write
+// acknowledged before it but costs twice the read capacity.
+type GetOptions struct {
+	Fields     []string
+	Consistent bool
+}
+
+// ListOptions narrows down a List call. The zero value scans a single
+// page of every todo that isn't soft deleted from the beginning of the table.
+// Cursor is opaque: pass back the next cursor of the previous page unchanged.
+// Fields and Consistent work like in GetOptions, except that queries of the
+// owner and position indexes are always eventually consistent.
+//
+// ByPosition pages through the caller's list in position order, lowest
+// first, from the position index. Only todos positioned after AfterPosition
+// are listed, if set. Todos written before positions existed aren't in the
+// index until they are moved.
+type ListOptions struct {
+	Limit          int
+	Cursor         string
+	Status         *bool
+	IncludeDeleted bool
+	Fields         []string
+	Consistent     bool
+	ByPosition     bool
+	AfterPosition  *float64
+}
+
+// SearchOptions narrows down a Search call to a page of the todos whose task
+// contains Query, ignoring case. Limit and Cursor work like in ListOptions,
+// except that the limit applies after filtering: a page only comes back
+// short when it is the last one.
+type SearchOptions struct {
+	Query  string
+	Limit  int
+	Cursor string
+}
+
+// newTodo builds the todo createTodo describes, owned by the subject of ctx.
+// Unless the client chose an id, it takes one from newID.
+func newTodo(ctx context.Context, createTodo CreateTodo, newID func() string) Todo {
+	createdAt := now()
+
+	id := createTodo.Id
+	if id == "" {
+		id = newID()
+	}
+
+	todo := Todo{
+		Task:      createTodo.Task,
+		TaskLower: strings.ToLower(createTodo.Task),
+		Status:    createTodo.Status,
+		Id:        id,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Version:   1,
+		Position:  newPosition(),
+		Owner:     subjectFrom(ctx),
+		List:      listOf(subjectFrom(ctx)),
+	}
+
+	if createTodo.ExpiresAt != nil {
+		todo.ExpiresAt = *createTodo.ExpiresAt
+	}
+
+	return todo
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// sequentialIDs returns an id generator counting up from
+// 00000000-0000-4000-8000-000000000001.
+func sequentialIDs() func() string {
+	var n int
+	return func() string {
+		n++
+		return fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+	}
+}
+
+func TestInsertUsesNewID(t *testing.T) {
+	memory := newMemoryStore()
+	memory.newID = sequentialIDs()
+
+	dynamo, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{}
+	})
+	dynamo.newID = sequentialIDs()
+
+	for _, tt := range []struct {
+		name  string
+		store TodoStore
+	}{
+		{name: "memory", store: memory},
+		{name: "dynamo", store: dynamo},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, want := range []string{"00000000-0000-4000-8000-000000000001", "00000000-0000-4000-8000-000000000002"} {
+				todo, err := tt.store.Insert(context.Background(), CreateTodo{Task: "write tests"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if todo.Id != want {
+					t.Errorf("Insert id = %s, want %s", todo.Id, want)
+				}
+			}
+		})
+	}
+
+	puts := fake.callsTo("PutItem")
+	if len(puts) != 2 {
+		t.Fatalf("PutItem called %d times, want 2", len(puts))
+	}
+	item, _ := puts[1].Input["Item"].(map[string]any)
+	if got := itemID(item); got != "00000000-0000-4000-8000-000000000002" {
+		t.Errorf("PutItem id = %s, want the generated one", got)
+	}
+}
+
+func TestInsertStatus(t *testing.T) {
+	dynamo, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		return map[string]any{}
+	})
+
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "omitted", body: `{"task":"write tests"}`, want: false},
+		{name: "false", body: `{"task":"write tests","status":false}`, want: false},
+		{name: "true", body: `{"task":"write tests","status":true}`, want: true},
+	}
+
+	for _, store := range []struct {
+		name  string
+		store TodoStore
+	}{
+		{name: "memory", store: newMemoryStore()},
+		{name: "dynamo", store: dynamo},
+	} {
+		for _, tt := range tests {
+			t.Run(store.name+"/"+tt.name, func(t *testing.T) {
+				res := serve(t, newHandler(store.store), request{HTTPMethod: "POST", Path: "/api/task", Body: tt.body})
+				if res.StatusCode != http.StatusCreated {
+					t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+				}
+
+				var todo Todo
+				decodeData(t, res, &todo)
+				if todo.Status != tt.want {
+					t.Errorf("created status = %t, want %t", todo.Status, tt.want)
+				}
+
+				got, err := store.store.Get(context.Background(), todo.Id, GetOptions{})
+				if store.name == "memory" && (err != nil || got.Status != tt.want) {
+					t.Errorf("stored status = %t (%v), want %t", got.Status, err, tt.want)
+				}
+			})
+		}
+	}
+
+	var puts []dynamoCall
+	for _, put := range fake.callsTo("PutItem") {
+		// Creating a todo also puts its first history entry.
+		if put.Input["TableName"] == "Todos" {
+			puts = append(puts, put)
+		}
+	}
+	if len(puts) != len(tests) {
+		t.Fatalf("PutItem called %d times, want %d", len(puts), len(tests))
+	}
+	for i, put := range puts {
+		item, _ := put.Input["Item"].(map[string]any)
+		status, _ := item["status"].(map[string]any)
+		if status["BOOL"] != tests[i].want {
+			t.Errorf("%s: PutItem status = %v, want %t", tests[i].name, item["status"], tests[i].want)
+		}
+	}
+
+	res := serve(t, newHandler(newMemoryStore()), request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"write tests","status":"done"}`})
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("non-boolean status: status = %d, want %d: %s", res.StatusCode, http.StatusUnprocessableEntity, res.Body)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// createTableIfMissing has main create the tables of template.yml at cold
+// start when they don't exist yet, so running against a fresh local emulator
+// needs no manual setup. It is off by default, and only allowed along with
+// DYNAMODB_ENDPOINT: in AWS the tables come from the stack, the function's
+// role may not create tables, and creating them takes longer than the 10
+// seconds Lambda gives the init phase. A typo in TABLE_NAME shouldn't quietly
+// get an empty table of its own either.
+var createTableIfMissing = os.Getenv("CREATE_TABLE_IF_MISSING") == "true"
+
+// errTablesNotLocal is returned by ensureTables outside of a local emulator.
+var errTablesNotLocal = errors.New("CREATE_TABLE_IF_MISSING is only supported with DYNAMODB_ENDPOINT")
+
+// tableActiveTimeout bounds waiting for a created table to become ACTIVE.
+// Local emulators create tables at once.
+const tableActiveTimeout = 30 * time.Second
+
+// tableDefinition is a table as template.yml declares it. Created tables are
+// billed per request, unlike the provisioned Todos table of the stack.
+type tableDefinition struct {
+	input *dynamodb.CreateTableInput
+	// ttl names the attribute expiring items, if any.
+	ttl string
+}
+
+// tableDefinitions returns the Todos table named table and the tables next to
+// it.
+func tableDefinitions(table string) []tableDefinition {
+	return []tableDefinition{
+		{
+			input: &dynamodb.CreateTableInput{
+				TableName: aws.String(table),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String("id"), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String("owner"), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String("list"), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String("position"), AttributeType: types.ScalarAttributeTypeN},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash},
+				},
+				GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+					{
+						IndexName: aws.String(ownerIndexName),
+						KeySchema: []types.KeySchemaElement{
+							{AttributeName: aws.String("owner"), KeyType: types.KeyTypeHash},
+						},
+						Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+					},
+					{
+						IndexName: aws.String(positionIndexName),
+						KeySchema: []types.KeySchemaElement{
+							{AttributeName: aws.String("list"), KeyType: types.KeyTypeHash},
+							{AttributeName: aws.String("position"), KeyType: types.KeyTypeRange},
+						},
+						Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+					},
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			},
+			ttl: "expiresAt",
+		},
+		{
+			input: &dynamodb.CreateTableInput{
+				TableName: aws.String(IdempotencyTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String("key"), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("key"), KeyType: types.KeyTypeHash},
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			},
+			ttl: "expiresAt",
+		},
+		{
+			input: &dynamodb.CreateTableInput{
+				TableName: aws.String(HistoryTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String("todoId"), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String("seq"), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("todoId"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("seq"), KeyType: types.KeyTypeRange},
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			},
+		},
+		{
+			input: &dynamodb.CreateTableInput{
+				TableName: aws.String(UniqueTaskTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String("key"), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("key"), KeyType: types.KeyTypeHash},
+				},
+				BillingMode: types.BillingModePayPerRequest,
+			},
+		},
+	}
+}
+
+// ensureTables creates whichever of the tables don't exist and waits until
+// all of them are ACTIVE, then enables TTL where it is disabled. Existing
+// tables are otherwise left as they are, whatever their schema, so running it
+// again changes nothing.
+func (s *dynamoStore) ensureTables(ctx context.Context) error {
+	if dynamoEndpoint == "" {
+		return errTablesNotLocal
+	}
+
+	for _, def := range tableDefinitions(s.table) {
+		err := s.ensureTable(ctx, def)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *dynamoStore) ensureTable(ctx context.Context, def tableDefinition) error {
+	name := aws.ToString(def.input.TableName)
+	describe := &dynamodb.DescribeTableInput{TableName: def.input.TableName}
+
+	created := false
+	_, err := s.client.DescribeTable(ctx, describe)
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case err == nil:
+	case errors.As(err, &notFound):
+		logger.Warn("Creating missing table", slog.String("table", name))
+
+		_, err = s.client.CreateTable(ctx, def.input)
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &inUse) {
+			// Another container got there first.
+			logger.Info("Table is already being created", slog.String("table", name))
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("creating table %s: %w", name, err)
+		}
+
+		created = true
+	default:
+		return fmt.Errorf("describing table %s: %w", name, err)
+	}
+
+	// A table created moments ago, here or by another container, is still
+	// CREATING.
+	err = dynamodb.NewTableExistsWaiter(s.client).Wait(ctx, describe, tableActiveTimeout)
+	if err != nil {
+		return fmt.Errorf("waiting for table %s: %w", name, err)
+	}
+
+	if def.ttl != "" {
+		err = s.ensureTTL(ctx, def)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("Table is active", slog.String("table", name), slog.Bool("created", created))
+
+	return nil
+}
+
+// ensureTTL enables TTL on def.ttl unless the table has TTL enabled already,
+// which covers tables created by an earlier cold start that failed before
+// enabling it. A table expiring items by another attribute is left alone.
+func (s *dynamoStore) ensureTTL(ctx context.Context, def tableDefinition) error {
+	name := aws.ToString(def.input.TableName)
+
+	res, err := s.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: def.input.TableName})
+	if err != nil {
+		return fmt.Errorf("describing TTL of table %s: %w", name, err)
+	}
+
+	if ttl := res.TimeToLiveDescription; ttl != nil {
+		switch ttl.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			return nil
+		}
+	}
+
+	logger.Warn("Enabling TTL", slog.String("table", name), slog.String("attribute", def.ttl))
+	_, err = s.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: def.input.TableName,
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(def.ttl),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("enabling TTL of table %s: %w", name, err)
+	}
+
+	return nil
+}
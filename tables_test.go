@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeTables answers the table management calls of ensureTables. The tables
+// in ttl exist, with the TTL status they map to.
+type fakeTables struct {
+	mu  sync.Mutex
+	ttl map[string]string
+}
+
+func (ft *fakeTables) handle(op string, input map[string]any) any {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	name, _ := input["TableName"].(string)
+	status, exists := ft.ttl[name]
+	switch {
+	case op == "CreateTable":
+		ft.ttl[name] = "DISABLED"
+		return map[string]any{"TableDescription": map[string]any{"TableName": name, "TableStatus": "CREATING"}}
+	case !exists:
+		return dynamoError{Type: "ResourceNotFoundException", Message: "Requested resource not found"}
+	case op == "DescribeTable":
+		return map[string]any{"Table": map[string]any{"TableName": name, "TableStatus": "ACTIVE"}}
+	case op == "DescribeTimeToLive":
+		return map[string]any{"TimeToLiveDescription": map[string]any{"TimeToLiveStatus": status}}
+	case op == "UpdateTimeToLive":
+		ft.ttl[name] = "ENABLED"
+		return map[string]any{"TimeToLiveSpecification": input["TimeToLiveSpecification"]}
+	default:
+		return map[string]any{}
+	}
+}
+
+// tablesOf returns the TableName of each call.
+func tablesOf(calls []dynamoCall) []string {
+	var names []string
+	for _, call := range calls {
+		name, _ := call.Input["TableName"].(string)
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func TestEnsureTables(t *testing.T) {
+	tables := &fakeTables{ttl: map[string]string{
+		// Created by a cold start that failed before enabling TTL.
+		"Todos":              "DISABLED",
+		IdempotencyTableName: "ENABLED",
+		UniqueTaskTableName:  "",
+	}}
+	store, fake := newFakeDynamo(t, tables.handle)
+
+	if err := store.ensureTables(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := tablesOf(fake.callsTo("CreateTable")); !slices.Equal(got, []string{HistoryTableName}) {
+		t.Errorf("created %v, want only the missing %s", got, HistoryTableName)
+	}
+	want := []string{"Todos", IdempotencyTableName}
+	slices.Sort(want)
+	if got := tablesOf(fake.callsTo("DescribeTimeToLive")); !slices.Equal(got, want) {
+		t.Errorf("described the TTL of %v, want %v", got, want)
+	}
+	if got := tablesOf(fake.callsTo("UpdateTimeToLive")); !slices.Equal(got, []string{"Todos"}) {
+		t.Errorf("enabled TTL on %v, want only the existing Todos table with it disabled", got)
+	}
+
+	// Everything is in place now.
+	updates := len(fake.callsTo("UpdateTimeToLive"))
+	creates := len(fake.callsTo("CreateTable"))
+	if err := store.ensureTables(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.callsTo("UpdateTimeToLive")) != updates || len(fake.callsTo("CreateTable")) != creates {
+		t.Error("second run changed the tables")
+	}
+}
+
+func TestEnsureTablesCreatesTTL(t *testing.T) {
+	tables := &fakeTables{ttl: map[string]string{}}
+	store, fake := newFakeDynamo(t, tables.handle)
+
+	if err := store.ensureTables(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.callsTo("CreateTable"); len(got) != 4 {
+		t.Errorf("created %v, want all 4 tables", tablesOf(got))
+	}
+	for _, call := range fake.callsTo("UpdateTimeToLive") {
+		spec, _ := call.Input["TimeToLiveSpecification"].(map[string]any)
+		if spec["AttributeName"] != "expiresAt" || spec["Enabled"] != true {
+			t.Errorf("TTL of %s = %v, want expiresAt enabled", call.Input["TableName"], spec)
+		}
+	}
+	if got := tablesOf(fake.callsTo("UpdateTimeToLive")); len(got) != 2 {
+		t.Errorf("enabled TTL on %v, want the Todos and idempotency tables", got)
+	}
+}
+
+func TestEnsureTablesNeedsLocalEndpoint(t *testing.T) {
+	store, fake := newFakeDynamo(t, (&fakeTables{ttl: map[string]string{}}).handle)
+	setFor(t, &dynamoEndpoint, "")
+
+	if err := store.ensureTables(context.Background()); !errors.Is(err, errTablesNotLocal) {
+		t.Errorf("ensureTables = %v, want %v", err, errTablesNotLocal)
+	}
+	if calls := fake.callsTo("DescribeTable"); len(calls) > 0 {
+		t.Errorf("described %v", tablesOf(calls))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// uniqueTasks rejects creating a todo whose task, ignoring case and
+// surrounding space, matches one of the owner's existing todos. Only creates
+// are checked: renaming a todo to a duplicate task is still possible.
+var uniqueTasks = os.Getenv("UNIQUE_TASKS") == "true"
+
+// UniqueTaskTableName stores a marker per owner and normalized task, which
+// creates put in the same transaction as the todo.
+const UniqueTaskTableName = "TodoUniqueTasks"
+
+// normalizeTask is the form of a task uniqueness is checked on.
+func normalizeTask(task string) string {
+	return strings.ToLower(strings.TrimSpace(task))
+}
+
+// uniqueTaskKey identifies the marker of task for owner. The task is hashed
+// to keep the key well below DynamoDB's key size limit.
+func uniqueTaskKey(owner string, task string) string {
+	sum := sha256.Sum256([]byte(normalizeTask(task)))
+	return owner + "#" + hex.EncodeToString(sum[:])
+}
+
+// holdsTask reports whether todo still claims its task, so that another todo
+// with the same task would be a duplicate. Soft deleted todos can be restored
+// and still claim it, expired ones don't.
+func holdsTask(todo *Todo, task string) bool {
+	if normalizeTask(todo.Task) != normalizeTask(task) {
+		return false
+	}
+
+	return todo.ExpiresAt == 0 || todo.ExpiresAt > time.Now().Unix()
+}
+
+type uniqueTaskRecord struct {
+	Key    string `dynamodbav:"key"`
+	TodoId string `dynamodbav:"todoId"`
+}
+
+// transactUnique writes items together with the marker of todo's task. Markers
+// aren't removed when their todo is deleted or renamed, so a marker whose todo
+// no longer holds the task is taken over. One that is still held fails with
+// ErrDuplicateTask. When other items fail their conditions, the error is
+// returned unchanged, with their cancellation reasons at the same indexes.
+func (s *dynamoStore) transactUnique(ctx context.Context, todo Todo, items []types.TransactWriteItem) error {
+	stale := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		marker, err := uniqueTaskPut(todo, stale)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append(items[:len(items):len(items)], marker),
+		})
+		if err == nil || !onlyConditionFailed(err, len(items)) {
+			return err
+		}
+
+		stale, err = s.staleMarker(ctx, todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return ErrDuplicateTask
+}
+
+// uniqueTaskPut puts the marker of todo's task, on the condition that there is
+// none yet or that it still points to the stale todo it replaces.
+func uniqueTaskPut(todo Todo, stale string) (types.TransactWriteItem, error) {
+	record, err := attributevalue.MarshalMap(uniqueTaskRecord{
+		Key:    uniqueTaskKey(todo.Owner, todo.Task),
+		TodoId: todo.Id,
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+
+	cond := expression.AttributeNotExists(expression.Name("key"))
+	if stale != "" {
+		cond = expression.Equal(expression.Name("todoId"), expression.Value(stale))
+	}
+
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:                 aws.String(UniqueTaskTableName),
+			Item:                      record,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		},
+	}, nil
+}
+
+// staleMarker returns the todo id of the marker for todo's task when that todo
+// no longer holds the task, "" when there is no marker, and ErrDuplicateTask
+// when the task is taken.
+func (s *dynamoStore) staleMarker(ctx context.Context, todo Todo) (string, error) {
+	key, err := attributevalue.Marshal(uniqueTaskKey(todo.Owner, todo.Task))
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(UniqueTaskTableName),
+		Key: map[string]types.AttributeValue{
+			"key": key,
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if result.Item == nil {
+		return "", nil
+	}
+
+	var record uniqueTaskRecord
+	err = attributevalue.UnmarshalMap(result.Item, &record)
+	if err != nil {
+		return "", err
+	}
+
+	holder, err := s.Get(ctx, record.TodoId, GetOptions{Consistent: true})
+	if errors.Is(err, ErrNotFound) {
+		return record.TodoId, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if holdsTask(holder, todo.Task) {
+		return "", ErrDuplicateTask
+	}
+
+	return record.TodoId, nil
+}
+
+// onlyConditionFailed reports whether err canceled a transaction because the
+// item at index failed its condition, and no other item did.
+func onlyConditionFailed(err error, index int) bool {
+	codes := cancellationCodes(err)
+	if len(codes) <= index || codes[index] != "ConditionalCheckFailed" {
+		return false
+	}
+
+	for i, code := range codes {
+		if i != index && code == "ConditionalCheckFailed" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// taskTaken reports whether another todo of todo's owner holds its task.
+// Callers hold s.mu.
+func (s *memoryStore) taskTaken(todo Todo) bool {
+	for _, other := range s.todos {
+		if other.Owner == todo.Owner && holdsTask(&other, todo.Task) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDuplicateTaskIsConflict(t *testing.T) {
+	setFor(t, &uniqueTasks, true)
+	h := newHandler(newMemoryStore())
+
+	res := serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"Write tests"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+	}
+
+	res = serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"  write TESTS "}`})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate: status = %d, want %d: %s", res.StatusCode, http.StatusConflict, res.Body)
+	}
+
+	res = serve(t, h, request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":"write docs"}`})
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("other task: status = %d, want %d: %s", res.StatusCode, http.StatusCreated, res.Body)
+	}
+}
+
+func TestDuplicateTaskIsConflictInDynamo(t *testing.T) {
+	setFor(t, &uniqueTasks, true)
+
+	holder := "6f1c0a52-3c2e-4f0e-9a57-1f7a3c2b9d10"
+	store, fake := newFakeDynamo(t, func(op string, input map[string]any) any {
+		switch {
+		case op == "TransactWriteItems":
+			// The todo is new, its task marker is taken.
+			return dynamoError{
+				Type:    "TransactionCanceledException",
+				Message: "Transaction cancelled",
+				Reasons: []string{"None", "ConditionalCheckFailed"},
+			}
+		case op == "GetItem" && input["TableName"] == UniqueTaskTableName:
+			return map[string]any{"Item": map[string]any{
+				"key":    map[string]any{"S": uniqueTaskKey("", "write tests")},
+				"todoId": map[string]any{"S": holder},
+			}}
+		case op == "GetItem":
+			return map[string]any{"Item": map[string]any{
+				"id":   map[string]any{"S": holder},
+				"task": map[string]any{"S": "Write tests"},
+			}}
+		default:
+			return map[string]any{}
+		}
+	})
+
+	res := serve(t, newHandler(store), request{HTTPMethod: "POST", Path: "/api/task", Body: `{"task":" write TESTS"}`})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("status = %d, want %d: %s", res.StatusCode, http.StatusConflict, res.Body)
+	}
+	if calls := fake.callsTo("PutItem"); len(calls) > 0 {
+		t.Errorf("todo put outside the transaction")
+	}
+}
+
+func TestNormalizeTask(t *testing.T) {
+	if uniqueTaskKey("alice", " Write Tests\n") != uniqueTaskKey("alice", "write tests") {
+		t.Error("tasks differing in case and space have different markers")
+	}
+	if uniqueTaskKey("alice", "write tests") == uniqueTaskKey("bob", "write tests") {
+		t.Error("owners share a marker")
+	}
+}
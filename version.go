@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The build metadata GET /api/version reports. The Makefile sets them at link
+// time with -ldflags "-X main.gitSHA=... -X main.buildTime=...", so a plain
+// go build reports "dev".
+var (
+	gitSHA    = "dev"
+	buildTime = "dev"
+)
+
+type VersionInfo struct {
+	GitSHA    string `json:"gitSha"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// processVersion tells which revision is deployed, e.g. to rule out a stale
+// deployment while debugging an incident.
+func (h *handler) processVersion(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+	return dataResponse(ctx, http.StatusOK, VersionInfo{
+		GitSHA:    gitSHA,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	})
+}